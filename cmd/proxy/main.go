@@ -8,8 +8,9 @@ import (
 	"time"
 
 	"github.com/go-chi/chi"
-	chiWare "github.com/go-chi/chi/middleware"
 	"github.com/go-pg/pg/v9"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/tsaron/anansi"
 	"github.com/tsaron/anansi/middleware"
 	"tsaron.com/traccar-proxy/pkg/config"
@@ -46,28 +47,52 @@ func main() {
 	}
 	log.Info().Msg("successfully connected to nats server")
 
+	var js nats.JetStreamContext
+	if env.NatsJetstream {
+		if js, err = config.SetupJetStream(nc, env); err != nil {
+			panic(err)
+		}
+		log.Info().Str("stream", env.NatsStream).Msg("successfully set up jetstream")
+	}
+
+	if env.AttributeNames != "" {
+		if err := traccar.LoadAttributeNames(env.AttributeNames); err != nil {
+			panic(err)
+		}
+	}
+
 	repo := traccar.NewRepo(db, "traccar.events", log)
 
 	sessions := anansi.NewSessionStore(env.Secret, env.Scheme, 0, nil)
 
+	// shares live changes with streaming clients and keeps the latest positions in memory
+	cache := proxy.NewPositionCache(repo, log)
+	tree := proxy.NewGroupTree(repo, log)
+	hub := proxy.NewHub(repo, cache, tree, log)
+
 	// API router
 	router := chi.NewRouter()
 
 	// setup app middlware
 	middleware.CORS(router, env.AppEnv, "https://*.tsaron.com", "https://*castui.netlify.app", "http://localhost:8080")
 	middleware.DefaultMiddleware(router)
+	router.Use(rest.QueryToken(env.Scheme))
 	router.Use(middleware.AttachLogger(log))
 	router.Use(middleware.TrackRequest())
 	router.Use(middleware.TrackResponse())
 	router.Use(middleware.Recoverer(env.AppEnv))
-	router.Use(chiWare.Timeout(time.Minute))
+	router.Use(rest.Timeout(time.Minute))
 
 	router.NotFound(func(w http.ResponseWriter, _ *http.Request) {
 		http.Error(w, "Whoops!! This route doesn't exist", http.StatusNotFound)
 	})
 
-	rest.Positions(router, sessions, repo)
-	rest.Devices(router, sessions, repo)
+	rest.Positions(router, sessions, repo, hub, cache)
+	rest.Devices(router, sessions, repo, env.DeviceOnlineTimeout)
+	rest.Groups(router, sessions, repo, env.DeviceOnlineTimeout)
+	rest.Reports(router, sessions, repo)
+	rest.Geofences(router, sessions, repo)
+	rest.Live(router, sessions, hub)
 
 	// mount API on app router
 	appRouter := chi.NewRouter()
@@ -81,16 +106,42 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	emitter, err := proxy.NewEmitter(nc, repo, log)
+	// only one replica gets to emit at a time
+	elector := proxy.NewElector(db, env.EmitterLockKey, log)
+
+	natsSink, err := proxy.NewNATSSink(nc, js, log)
 	if err != nil {
 		panic(err)
 	}
+	sinks := []proxy.Sink{natsSink}
+
+	if env.WebhookTargets != "" {
+		// unsigned deliveries can't be told apart from forged ones
+		if len(env.WebhookSecret) == 0 {
+			panic(fmt.Errorf("webhook targets are set without a webhook secret"))
+		}
+
+		targets, err := proxy.LoadWebhookTargets(env.WebhookTargets)
+		if err != nil {
+			panic(err)
+		}
+		sinks = append(sinks, proxy.NewWebhookSink(repo, tree, env.WebhookSecret, targets, log))
+		log.Info().Int("targets", len(targets)).Msg("successfully set up webhooks")
+	}
+
+	geofences := proxy.NewGeofenceEvaluator(repo, log)
+	emitter := proxy.NewEmitter(repo, elector, geofences, log, sinks...)
 
 	done := new(sync.WaitGroup)
 
 	emitter.Run(ctx, done)
+	hub.Run(ctx, done)
 
 	go anansi.CancelOnInterrupt(cancel, log)
+	go anansi.RunServer(ctx, log, &http.Server{
+		Addr:    fmt.Sprintf(":%d", env.MetricsPort),
+		Handler: promhttp.Handler(),
+	})
 	anansi.RunServer(ctx, log, &http.Server{
 		Addr:    fmt.Sprintf(":%d", env.Port),
 		Handler: appRouter,
@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 // Env is the expected config values from the process's environment
 type Env struct {
 	AppEnv string `default:"dev" split_words:"true"`
@@ -7,11 +9,23 @@ type Env struct {
 	Port   int    `required:"true"`
 	Scheme string `required:"true"`
 	Secret []byte `required:"true"`
+	// MetricsPort serves prometheus metrics apart from the API so they aren't public
+	MetricsPort int `default:"9090" split_words:"true"`
 
 	NatsUrl      string `required:"true" split_words:"true"`
 	NatsUser     string `split_words:"true"`
 	NatsPassword string `split_words:"true"`
 
+	// NatsJetstream switches the emitter to durable publishing on a JetStream stream
+	NatsJetstream    bool          `split_words:"true"`
+	NatsStream       string        `default:"TRACCAR" split_words:"true"`
+	NatsStreamMaxAge time.Duration `default:"720h" split_words:"true"`
+
+	// WebhookTargets is the path to a JSON file listing the webhooks to deliver messages to
+	WebhookTargets string `split_words:"true"`
+	// WebhookSecret signs webhook deliveries. It's required when there are webhook targets.
+	WebhookSecret []byte `split_words:"true"`
+
 	PostgresHost       string `required:"true" split_words:"true"`
 	PostgresPort       int    `required:"true" split_words:"true"`
 	PostgresSecureMode bool   `required:"true" split_words:"true"`
@@ -20,4 +34,13 @@ type Env struct {
 	PostgresDatabase   string `required:"true" split_words:"true"`
 
 	HeadlessTimeout string `required:"true" split_words:"true"`
+
+	// AttributeNames is the path to a JSON file renaming traccar attributes passed through as extras
+	AttributeNames string `split_words:"true"`
+
+	// DeviceOnlineTimeout is how long after its last update a device is considered offline
+	DeviceOnlineTimeout time.Duration `default:"5m" split_words:"true"`
+
+	// EmitterLockKey is the postgres advisory lock replicas compete for to become the emitter
+	EmitterLockKey int64 `default:"730211" split_words:"true"`
 }
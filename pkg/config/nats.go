@@ -1,6 +1,14 @@
 package config
 
-import "github.com/nats-io/nats.go"
+import (
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// StreamSubjects are the subjects captured by the JetStream stream when running
+// in durable mode.
+var StreamSubjects = []string{"traccar.positions.>", "traccar.events.>", "traccar.devices.>", "traccar.geofences.>"}
 
 func SetupNats(env Env) (*nats.Conn, error) {
 	opts := []nats.Option{nats.Name(env.Name)}
@@ -13,3 +21,31 @@ func SetupNats(env Env) (*nats.Conn, error) {
 
 	return nc, err
 }
+
+// SetupJetStream creates a JetStream context and makes sure the stream described by env
+// exists and captures all the subjects we publish on.
+func SetupJetStream(nc *nats.Conn, env Env) (nats.JetStreamContext, error) {
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &nats.StreamConfig{
+		Name:       env.NatsStream,
+		Subjects:   StreamSubjects,
+		Retention:  nats.LimitsPolicy,
+		Storage:    nats.FileStorage,
+		MaxAge:     env.NatsStreamMaxAge,
+		Duplicates: 2 * time.Minute,
+	}
+
+	// update the stream if it exists so subject changes get picked up
+	if _, err := js.StreamInfo(env.NatsStream); err == nil {
+		_, err = js.UpdateStream(cfg)
+		return js, err
+	}
+
+	_, err = js.AddStream(cfg)
+
+	return js, err
+}
@@ -0,0 +1,90 @@
+package export
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+)
+
+const (
+	GPXContentType = "application/gpx+xml"
+	// namespace of Garmin's track point extension, which most tools read speed and course from
+	gpxTPXNamespace = "http://www.garmin.com/xmlschemas/TrackPointExtension/v2"
+)
+
+type gpx struct {
+	XMLName xml.Name   `xml:"http://www.topografix.com/GPX/1/1 gpx"`
+	TPX     string     `xml:"xmlns:gpxtpx,attr"`
+	Version string     `xml:"version,attr"`
+	Creator string     `xml:"creator,attr"`
+	Name    string     `xml:"metadata>name"`
+	Tracks  []gpxTrack `xml:"trk"`
+}
+
+type gpxTrack struct {
+	Name     string       `xml:"name"`
+	Number   uint         `xml:"number,omitempty"`
+	Segments []gpxSegment `xml:"trkseg"`
+}
+
+type gpxSegment struct {
+	Points []gpxPoint `xml:"trkpt"`
+}
+
+type gpxPoint struct {
+	Latitude   float64       `xml:"lat,attr"`
+	Longitude  float64       `xml:"lon,attr"`
+	Elevation  float64       `xml:"ele"`
+	Time       time.Time     `xml:"time"`
+	Extensions gpxExtensions `xml:"extensions"`
+}
+
+// GPX 1.1 has no speed or course on track points so they go in extensions. The schema
+// only allows elements from other namespaces there, so we use Garmin's.
+type gpxExtensions struct {
+	TrackPoint gpxTrackPointExtension `xml:"gpxtpx:TrackPointExtension"`
+}
+
+type gpxTrackPointExtension struct {
+	Speed  float64 `xml:"gpxtpx:speed"`
+	Course float64 `xml:"gpxtpx:course"`
+}
+
+// WriteGPX writes tracks as a GPX 1.1 document with a trk per track and a trkseg per segment. Speeds are in metres per second.
+func WriteGPX(w io.Writer, name string, tracks ...Track) error {
+	doc := gpx{TPX: gpxTPXNamespace, Version: "1.1", Creator: creator, Name: name}
+
+	for _, t := range tracks {
+		trk := gpxTrack{Name: t.Name, Number: t.Device}
+
+		for _, seg := range t.Segments {
+			var s gpxSegment
+			for _, p := range seg {
+				s.Points = append(s.Points, gpxPoint{
+					Latitude:  p.Latitude,
+					Longitude: p.Longitude,
+					Elevation: p.Altitude,
+					Time:      p.RecordedAt.UTC(),
+					Extensions: gpxExtensions{
+						TrackPoint: gpxTrackPointExtension{
+							Speed:  p.Speed * knotsToMps,
+							Course: p.Course,
+						},
+					},
+				})
+			}
+			trk.Segments = append(trk.Segments, s)
+		}
+
+		doc.Tracks = append(doc.Tracks, trk)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+
+	return enc.Encode(doc)
+}
@@ -0,0 +1,147 @@
+package export
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"tsaron.com/traccar-proxy/pkg/model"
+)
+
+var testPositions = []model.Position{
+	{
+		Latitude:   6.5,
+		Longitude:  3.25,
+		Altitude:   10,
+		Speed:      10,
+		Course:     90,
+		RecordedAt: time.Date(2021, 3, 1, 10, 0, 0, 0, time.FixedZone("WAT", 3600)),
+	},
+	{
+		Latitude:   6.6,
+		Longitude:  3.3,
+		RecordedAt: time.Date(2021, 3, 1, 10, 1, 0, 0, time.UTC),
+	},
+}
+
+// checkXML fails t unless doc is well formed and contains every fragment in want, in order
+func checkXML(t *testing.T, doc string, want []string) {
+	t.Helper()
+
+	dec := xml.NewDecoder(strings.NewReader(doc))
+	for {
+		if _, err := dec.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("invalid XML: %v\n%s", err, doc)
+		}
+	}
+
+	rest := doc
+	for _, w := range want {
+		i := strings.Index(rest, w)
+		if i < 0 {
+			t.Fatalf("missing %q after the earlier fragments in\n%s", w, doc)
+		}
+		rest = rest[i+len(w):]
+	}
+}
+
+func TestWriteGPX(t *testing.T) {
+	tests := []struct {
+		name   string
+		tracks []Track
+		want   []string
+	}{
+		{
+			name: "no tracks",
+			want: []string{
+				`<gpx xmlns="http://www.topografix.com/GPX/1/1" xmlns:gpxtpx="` + gpxTPXNamespace + `" version="1.1" creator="traccar-proxy">`,
+				`<name>Day</name>`,
+			},
+		},
+		{
+			name:   "escapes names",
+			tracks: []Track{{Name: "Bus <1> & co", Device: 7}},
+			want:   []string{`<trk>`, `<name>Bus &lt;1&gt; &amp; co</name>`, `<number>7</number>`, `</trk>`},
+		},
+		{
+			name:   "points",
+			tracks: []Track{{Name: "Bus", Device: 7, Segments: [][]model.Position{testPositions}}},
+			want: []string{
+				`<trkseg>`,
+				`<trkpt lat="6.5" lon="3.25">`, `<ele>10</ele>`, `<time>2021-03-01T09:00:00Z</time>`,
+				`<gpxtpx:TrackPointExtension>`, `<gpxtpx:speed>5.14444</gpxtpx:speed>`, `<gpxtpx:course>90</gpxtpx:course>`,
+				`<trkpt lat="6.6" lon="3.3">`, `<time>2021-03-01T10:01:00Z</time>`,
+				`</trkseg>`,
+			},
+		},
+		{
+			name:   "segments",
+			tracks: []Track{{Name: "Bus", Segments: [][]model.Position{testPositions[:1], testPositions[1:]}}},
+			want:   []string{`<trkseg>`, `lat="6.5"`, `</trkseg>`, `<trkseg>`, `lat="6.6"`, `</trkseg>`},
+		},
+		{
+			name:   "tracks",
+			tracks: []Track{{Name: "Bus"}, {Name: "Van"}},
+			want:   []string{`<name>Bus</name>`, `</trk>`, `<name>Van</name>`, `</trk>`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteGPX(&buf, "Day", tt.tracks...); err != nil {
+				t.Fatal(err)
+			}
+
+			checkXML(t, buf.String(), append([]string{xml.Header}, tt.want...))
+		})
+	}
+}
+
+// GPX only allows elements from other namespaces in extensions
+func TestGPXExtensionNamespace(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteGPX(&buf, "Day", Track{Name: "Bus", Segments: [][]model.Position{testPositions}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec := xml.NewDecoder(&buf)
+	depth, inExtensions, found := 0, 0, 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		switch el := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if inExtensions > 0 {
+				found++
+				if el.Name.Space != gpxTPXNamespace {
+					t.Errorf("%s is in namespace %q, want %q", el.Name.Local, el.Name.Space, gpxTPXNamespace)
+				}
+			} else if el.Name.Local == "extensions" {
+				inExtensions = depth
+			}
+		case xml.EndElement:
+			if depth == inExtensions {
+				inExtensions = 0
+			}
+			depth--
+		}
+	}
+
+	// a TrackPointExtension with speed and course for each point
+	if found != 3*len(testPositions) {
+		t.Errorf("found %d elements in extensions, want %d", found, 3*len(testPositions))
+	}
+}
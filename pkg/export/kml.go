@@ -0,0 +1,125 @@
+package export
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const KMLContentType = "application/vnd.google-earth.kml+xml"
+
+type kml struct {
+	XMLName  xml.Name    `xml:"http://www.opengis.net/kml/2.2 kml"`
+	GX       string      `xml:"xmlns:gx,attr"`
+	Document kmlDocument `xml:"Document"`
+}
+
+type kmlDocument struct {
+	Name       string         `xml:"name"`
+	Schema     kmlSchema      `xml:"Schema"`
+	Placemarks []kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlSchema struct {
+	ID     string           `xml:"id,attr"`
+	Fields []kmlSchemaField `xml:"gx:SimpleArrayField"`
+}
+
+type kmlSchemaField struct {
+	Name        string `xml:"name,attr"`
+	Type        string `xml:"type,attr"`
+	DisplayName string `xml:"displayName"`
+}
+
+type kmlPlacemark struct {
+	Name  string        `xml:"name"`
+	Track kmlMultiTrack `xml:"gx:MultiTrack"`
+}
+
+type kmlMultiTrack struct {
+	AltitudeMode string     `xml:"altitudeMode"`
+	Interpolate  int        `xml:"gx:interpolate"`
+	Tracks       []kmlTrack `xml:"gx:Track"`
+}
+
+// kmlTrack is a gx:Track, which lists the times of its points before their coordinates
+type kmlTrack struct {
+	When         []string        `xml:"when"`
+	Coords       []string        `xml:"gx:coord"`
+	ExtendedData kmlExtendedData `xml:"ExtendedData"`
+}
+
+type kmlExtendedData struct {
+	SchemaData kmlSchemaData `xml:"SchemaData"`
+}
+
+type kmlSchemaData struct {
+	SchemaURL string         `xml:"schemaUrl,attr"`
+	Arrays    []kmlArrayData `xml:"gx:SimpleArrayData"`
+}
+
+type kmlArrayData struct {
+	Name   string   `xml:"name,attr"`
+	Values []string `xml:"gx:value"`
+}
+
+// WriteKML writes tracks as a KML 2.2 document with a placemark per track and a
+// gx:Track per segment. Speeds are in kilometres per hour.
+func WriteKML(w io.Writer, name string, tracks ...Track) error {
+	doc := kml{
+		GX: "http://www.google.com/kml/ext/2.2",
+		Document: kmlDocument{
+			Name: name,
+			Schema: kmlSchema{
+				ID: "position",
+				Fields: []kmlSchemaField{
+					{Name: "speed", Type: "float", DisplayName: "Speed (km/h)"},
+					{Name: "course", Type: "float", DisplayName: "Course (degrees)"},
+				},
+			},
+		},
+	}
+
+	for _, t := range tracks {
+		pm := kmlPlacemark{
+			Name:  t.Name,
+			Track: kmlMultiTrack{AltitudeMode: "absolute"},
+		}
+
+		for _, seg := range t.Segments {
+			speed := kmlArrayData{Name: "speed"}
+			course := kmlArrayData{Name: "course"}
+			track := kmlTrack{}
+
+			for _, p := range seg {
+				track.When = append(track.When, p.RecordedAt.UTC().Format(time.RFC3339))
+				track.Coords = append(track.Coords, fmt.Sprintf("%s %s %s", kmlFloat(p.Longitude), kmlFloat(p.Latitude), kmlFloat(p.Altitude)))
+				speed.Values = append(speed.Values, kmlFloat(p.Speed*knotsToKmh))
+				course.Values = append(course.Values, kmlFloat(p.Course))
+			}
+
+			track.ExtendedData.SchemaData = kmlSchemaData{
+				SchemaURL: "#position",
+				Arrays:    []kmlArrayData{speed, course},
+			}
+			pm.Track.Tracks = append(pm.Track.Tracks, track)
+		}
+
+		doc.Document.Placemarks = append(doc.Document.Placemarks, pm)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+
+	return enc.Encode(doc)
+}
+
+func kmlFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,66 @@
+package export
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+
+	"tsaron.com/traccar-proxy/pkg/model"
+)
+
+func TestWriteKML(t *testing.T) {
+	tests := []struct {
+		name   string
+		tracks []Track
+		want   []string
+	}{
+		{
+			name: "no tracks",
+			want: []string{
+				`<kml xmlns="http://www.opengis.net/kml/2.2" xmlns:gx="http://www.google.com/kml/ext/2.2">`,
+				`<name>Day</name>`,
+				`<Schema id="position">`, `<gx:SimpleArrayField name="speed" type="float">`,
+			},
+		},
+		{
+			name:   "escapes names",
+			tracks: []Track{{Name: "Bus <1> & co"}},
+			want:   []string{`<Placemark>`, `<name>Bus &lt;1&gt; &amp; co</name>`, `<altitudeMode>absolute</altitudeMode>`},
+		},
+		{
+			// times come before coordinates, in the same order
+			name:   "points",
+			tracks: []Track{{Name: "Bus", Segments: [][]model.Position{testPositions}}},
+			want: []string{
+				`<gx:Track>`,
+				`<when>2021-03-01T09:00:00Z</when>`, `<when>2021-03-01T10:01:00Z</when>`,
+				`<gx:coord>3.25 6.5 10</gx:coord>`, `<gx:coord>3.3 6.6 0</gx:coord>`,
+				`<SchemaData schemaUrl="#position">`,
+				`<gx:SimpleArrayData name="speed">`, `<gx:value>18.52</gx:value>`, `<gx:value>0</gx:value>`,
+				`<gx:SimpleArrayData name="course">`, `<gx:value>90</gx:value>`,
+				`</gx:Track>`,
+			},
+		},
+		{
+			name:   "segments",
+			tracks: []Track{{Name: "Bus", Segments: [][]model.Position{testPositions[:1], testPositions[1:]}}},
+			want:   []string{`<gx:MultiTrack>`, `<gx:coord>3.25 6.5 10</gx:coord>`, `</gx:Track>`, `<gx:coord>3.3 6.6 0</gx:coord>`, `</gx:Track>`},
+		},
+		{
+			name:   "tracks",
+			tracks: []Track{{Name: "Bus"}, {Name: "Van"}},
+			want:   []string{`<name>Bus</name>`, `</Placemark>`, `<name>Van</name>`, `</Placemark>`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteKML(&buf, "Day", tt.tracks...); err != nil {
+				t.Fatal(err)
+			}
+
+			checkXML(t, buf.String(), append([]string{xml.Header}, tt.want...))
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package export
+
+import "tsaron.com/traccar-proxy/pkg/model"
+
+const (
+	// knots to metres per second
+	knotsToMps = 0.514444
+	// knots to kilometres per hour
+	knotsToKmh = 1.852
+	creator    = "traccar-proxy"
+)
+
+// Track is the path of a device over a period, split into segments like trips.
+// Positions in a segment are sorted oldest first.
+type Track struct {
+	Name     string
+	Device   uint
+	Segments [][]model.Position
+}
+
+// SplitTrips breaks positions, sorted oldest first, into a segment per trip.
+// Positions outside all trips are left out.
+func SplitTrips(positions []model.Position, trips []model.Trip) [][]model.Position {
+	var segments [][]model.Position
+
+	i := 0
+	for _, t := range trips {
+		for i < len(positions) && positions[i].RecordedAt.Before(t.StartedAt) {
+			i++
+		}
+
+		start := i
+		for i < len(positions) && !positions[i].RecordedAt.After(t.EndedAt) {
+			i++
+		}
+
+		if i > start {
+			segments = append(segments, positions[start:i])
+		}
+	}
+
+	return segments
+}
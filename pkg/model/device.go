@@ -1,7 +1,48 @@
 package model
 
+import "time"
+
 type Device struct {
-	ID         uint   `json:"id"`
-	Name       string `json:"name"`
-	ExternalID string `json:"external_id"`
+	ID           uint                   `json:"id"`
+	Name         string                 `json:"name"`
+	ExternalID   string                 `json:"external_id"`
+	Phone        string                 `json:"phone,omitempty"`
+	Model        string                 `json:"model,omitempty"`
+	Contact      string                 `json:"contact,omitempty"`
+	Category     string                 `json:"category,omitempty"`
+	Group        uint                   `json:"group_id,omitempty"`
+	Disabled     bool                   `json:"disabled"`
+	LastPosition uint                   `json:"last_position_id,omitempty"`
+	LastUpdate   *time.Time             `json:"last_update,omitempty"`
+	Online       *bool                  `json:"online,omitempty"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+}
+
+type TraccarDevice struct {
+	ID         uint         `json:"id"`
+	UpdatedAt  ISOWithoutTZ `json:"lastupdate"`
+	Name       string       `json:"name"`
+	ExternalID string       `json:"uniqueid"`
+	Position   uint         `json:"positionid"`
+	Payload    string       `json:"attributes"`
+	Phone      string       `json:"phone"`
+	Model      string       `json:"model"`
+	Contact    string       `json:"contact"`
+	Category   string       `json:"category"`
+	Disabled   bool         `json:"disabled"`
+	Group      uint         `json:"groupid"`
+}
+
+// DeviceChange describes a device being created, updated or deleted in traccar
+type DeviceChange struct {
+	Type   string `json:"type"`
+	Device Device `json:"data"`
+	// fields an update changed. It's left out when the previous version isn't known.
+	Changes map[string]FieldChange `json:"changes,omitempty"`
+}
+
+// FieldChange is the old and new value of an updated field
+type FieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
 }
@@ -0,0 +1,66 @@
+package model
+
+import "time"
+
+// EventType is the kind of event as named by traccar
+type EventType string
+
+const (
+	EventCommandResult   EventType = "commandResult"
+	EventDeviceOnline    EventType = "deviceOnline"
+	EventDeviceUnknown   EventType = "deviceUnknown"
+	EventDeviceOffline   EventType = "deviceOffline"
+	EventDeviceInactive  EventType = "deviceInactive"
+	EventDeviceMoving    EventType = "deviceMoving"
+	EventDeviceStopped   EventType = "deviceStopped"
+	EventDeviceOverspeed EventType = "deviceOverspeed"
+	EventDeviceFuelDrop  EventType = "deviceFuelDrop"
+	EventGeofenceEnter   EventType = "geofenceEnter"
+	EventGeofenceExit    EventType = "geofenceExit"
+	EventAlarm           EventType = "alarm"
+	EventIgnitionOn      EventType = "ignitionOn"
+	EventIgnitionOff     EventType = "ignitionOff"
+	EventMaintenance     EventType = "maintenance"
+	EventTextMessage     EventType = "textMessage"
+	EventDriverChanged   EventType = "driverChanged"
+)
+
+type TraccarEvent struct {
+	ID          uint
+	Type        EventType    `json:"type"`
+	CreatedAt   ISOWithoutTZ `json:"servertime"`
+	Device      uint         `json:"deviceid"`
+	Position    uint         `json:"positionid"`
+	Geofence    uint         `json:"geofenceid"`
+	Maintenance uint         `json:"maintenanceid"`
+	Payload     string       `json:"attributes"`
+}
+
+type TraccarEventAttributes struct {
+	Alarm          string  `json:"alarm,omitempty"`
+	Speed          float64 `json:"speed,omitempty"`
+	SpeedLimit     float64 `json:"speedLimit,omitempty"`
+	Message        string  `json:"message,omitempty"`
+	Result         string  `json:"result,omitempty"`
+	DriverUniqueID string  `json:"driverUniqueId,omitempty"`
+}
+
+type Event struct {
+	ID          uint            `json:"id"`
+	Type        EventType       `json:"type"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Device      uint            `json:"device_id"`
+	Position    uint            `json:"position_id,omitempty"`
+	Geofence    uint            `json:"geofence_id,omitempty"`
+	Maintenance uint            `json:"maintenance_id,omitempty"`
+	Meta        EventAttributes `json:"metadata"`
+}
+
+type EventAttributes struct {
+	Alarm      string  `json:"alarm,omitempty"`
+	Speed      float64 `json:"speed,omitempty"`
+	SpeedLimit float64 `json:"speed_limit,omitempty"`
+	Message    string  `json:"message,omitempty"`
+	Result     string  `json:"command_result,omitempty"`
+	Driver     string  `json:"driver_id,omitempty"`
+}
@@ -0,0 +1,34 @@
+package model
+
+import "time"
+
+const (
+	GeofenceCircle  = "circle"
+	GeofencePolygon = "polygon"
+)
+
+type Point struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Geofence is an area devices can enter and exit. Circles are described by their
+// center and radius in metres, polygons by their points.
+type Geofence struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Latitude  float64   `json:"latitude,omitempty"`
+	Longitude float64   `json:"longitude,omitempty"`
+	Radius    float64   `json:"radius,omitempty"`
+	Points    []Point   `json:"points,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// GeofenceCrossing is a device entering or exiting a geofence
+type GeofenceCrossing struct {
+	Type     string   `json:"type"`
+	Geofence Geofence `json:"geofence"`
+	Position Position `json:"position"`
+}
@@ -0,0 +1,9 @@
+package model
+
+// Group is a set of devices in traccar. Groups can be nested under a parent group.
+type Group struct {
+	ID         uint                   `json:"id"`
+	Name       string                 `json:"name"`
+	Parent     uint                   `json:"parent_id,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
@@ -8,12 +8,20 @@ import (
 
 type ISOWithoutTZ time.Time
 
+// layout postgres uses for timestamps without a time zone in JSON
+const isoWithoutTZ = "2006-01-02T15:04:05.999"
+
 // imeplement Marshaler und Unmarshaler interface
 func (i *ISOWithoutTZ) UnmarshalJSON(b []byte) error {
+	// leave nullable columns as zero time
+	if string(b) == "null" {
+		return nil
+	}
+
 	// remove quotes
 	tStr := strings.Trim(string(b), "\"")
 
-	t, err := time.Parse("2006-01-02T15:04:05.999", tStr)
+	t, err := time.Parse(isoWithoutTZ, tStr)
 	if err != nil {
 		return err
 	}
@@ -25,7 +33,12 @@ func (i *ISOWithoutTZ) UnmarshalJSON(b []byte) error {
 }
 
 func (i ISOWithoutTZ) MarshalJSON() ([]byte, error) {
-	return json.Marshal(i)
+	t := time.Time(i)
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+
+	return json.Marshal(t.Format(isoWithoutTZ))
 }
 
 type TraccarPosition struct {
@@ -86,6 +99,15 @@ type Position struct {
 	Speed      float64    `json:"speed"`
 	Course     float64    `json:"course"`
 	Meta       Attributes `json:"metadata"`
+	// attributes that couldn't be read, which are left out of Meta
+	Warnings []AttributeWarning `json:"warnings,omitempty"`
+}
+
+// AttributeWarning describes a traccar attribute that couldn't be read
+type AttributeWarning struct {
+	Attribute string          `json:"attribute"`
+	Value     json.RawMessage `json:"value,omitempty"`
+	Message   string          `json:"message"`
 }
 
 type Attributes struct {
@@ -108,4 +130,6 @@ type Attributes struct {
 	MilDistance         float32 `json:"mil_distance,omitempty"`
 	Satellites          uint    `json:"satellites,omitempty"`
 	TripFuelConsumption float32 `json:"trip_fuel_used,omitempty"`
+	// attributes without a field of their own, named as traccar reports them unless renamed
+	Extra map[string]json.RawMessage `json:"extra,omitempty"`
 }
@@ -0,0 +1,45 @@
+package model
+
+import "time"
+
+// Trip is a continuous stretch of movement by a device. Distances are in metres,
+// durations in seconds and speeds in knots like positions.
+type Trip struct {
+	Device         uint      `json:"device_id"`
+	StartedAt      time.Time `json:"started_at"`
+	EndedAt        time.Time `json:"ended_at"`
+	StartLatitude  float64   `json:"start_latitude"`
+	StartLongitude float64   `json:"start_longitude"`
+	EndLatitude    float64   `json:"end_latitude"`
+	EndLongitude   float64   `json:"end_longitude"`
+	Distance       float64   `json:"distance"`
+	Duration       int64     `json:"duration"`
+	MaxSpeed       float64   `json:"max_speed"`
+	AverageSpeed   float64   `json:"average_speed"`
+	FuelUsed       float32   `json:"fuel_used"`
+}
+
+// Stop is a period a device spent around the same place. Idling is set when the
+// ignition stayed on throughout.
+type Stop struct {
+	Device     uint      `json:"device_id"`
+	Latitude   float64   `json:"latitude"`
+	Longitude  float64   `json:"longitude"`
+	ArrivedAt  time.Time `json:"arrived_at"`
+	DepartedAt time.Time `json:"departed_at"`
+	Duration   int64     `json:"duration"`
+	Idling     bool      `json:"idling"`
+}
+
+// Summary is the rollup of a device's activity over a period starting at Period.
+// EngineHours is the time in hours the ignition was on.
+type Summary struct {
+	Device       uint      `json:"device_id"`
+	Period       time.Time `json:"period"`
+	Positions    int       `json:"positions"`
+	Distance     float64   `json:"distance"`
+	EngineHours  float64   `json:"engine_hours"`
+	MaxSpeed     float64   `json:"max_speed"`
+	AverageSpeed float64   `json:"average_speed"`
+	FuelUsed     float64   `json:"fuel_used"`
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog"
+	"tsaron.com/traccar-proxy/pkg/model"
+	"tsaron.com/traccar-proxy/pkg/traccar"
+)
+
+var (
+	cacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "traccar_proxy_position_cache_hits_total",
+		Help: "Number of latest position lookups served from memory",
+	})
+	cacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "traccar_proxy_position_cache_misses_total",
+		Help: "Number of latest position lookups that had to go to the database",
+	})
+)
+
+// PositionCache keeps the newest position of every device in memory. It is warmed from
+// the database and kept up to date by the hub.
+type PositionCache struct {
+	log  zerolog.Logger
+	repo *traccar.Repo
+
+	mu        sync.RWMutex
+	positions map[uint]model.Position
+}
+
+func NewPositionCache(repo *traccar.Repo, log zerolog.Logger) *PositionCache {
+	subLogger := log.With().Str("source", "position-cache").Logger()
+	return &PositionCache{log: subLogger, repo: repo, positions: make(map[uint]model.Position)}
+}
+
+// Warm loads the latest position of every device
+func (c *PositionCache) Warm(ctx context.Context) error {
+	tps, err := c.repo.LatestPositions(ctx, traccar.DeviceFilter{})
+	if err != nil {
+		return err
+	}
+
+	for _, tp := range tps {
+		c.Update(traccar.TransformPosition(c.repo.RemoveTZ(&tp)))
+	}
+
+	c.log.Info().Int("devices", len(tps)).Msg("warmed position cache")
+
+	return nil
+}
+
+// Get returns the cached position of a device
+func (c *PositionCache) Get(device uint) (model.Position, bool) {
+	c.mu.RLock()
+	p, ok := c.positions[device]
+	c.mu.RUnlock()
+
+	if ok {
+		cacheHits.Inc()
+	} else {
+		cacheMisses.Inc()
+	}
+
+	return p, ok
+}
+
+// Update stores p if it's newer than the cached position of its device
+func (c *PositionCache) Update(p model.Position) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if old, ok := c.positions[p.Device]; ok && old.RecordedAt.After(p.RecordedAt) {
+		return
+	}
+
+	c.positions[p.Device] = p
+}
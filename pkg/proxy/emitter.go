@@ -4,18 +4,45 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"sync"
+	"time"
 
-	"github.com/nats-io/nats.go"
 	"github.com/rs/zerolog"
 	"tsaron.com/traccar-proxy/pkg/model"
 	"tsaron.com/traccar-proxy/pkg/traccar"
 )
 
+const (
+	// name of the checkpoint tracking emitted positions
+	positionCheckpoint = "emitter.positions"
+	// how many positions to load at a time when catching up
+	catchUpBatch = 500
+	// how often the checkpoint is saved, and catching up retried after a failed publish
+	checkpointInterval = 5 * time.Second
+	// how long after a position we assume every position with a lower ID has committed.
+	// Traccar inserts from many threads so IDs can commit out of order.
+	settleDelay = 30 * time.Second
+)
+
 type Emitter struct {
-	log  zerolog.Logger
-	repo *traccar.Repo
-	conn *nats.EncodedConn
+	log       zerolog.Logger
+	repo      *traccar.Repo
+	elector   *Elector
+	geofences *GeofenceEvaluator
+	sinks     []Sink
+
+	// every position up to this ID has been emitted
+	checkpoint uint
+	// checkpoint as last saved to the database
+	saved uint
+	// set when a position failed to publish, so catching up is retried
+	stalled bool
+	// positions after the checkpoint that have been emitted, so catch-ups and live
+	// notifications don't send them twice. Pruned as the checkpoint moves.
+	emitted map[uint]bool
+	// newest servertime we've seen, which decides when positions have settled
+	newest time.Time
 }
 
 type PositionEvent struct {
@@ -23,68 +50,372 @@ type PositionEvent struct {
 	Position model.TraccarPosition `json:"data"`
 }
 
-// TODO: https://github.com/nats-io/nats.go/blob/master/examples/nats-qsub/main.go (options)
+type DeviceEvent struct {
+	Action string              `json:"action"`
+	Device model.TraccarDevice `json:"data"`
+	// the previous values of the columns an update changed
+	Old json.RawMessage `json:"old"`
+}
 
-func NewEmitter(conn *nats.Conn, repo *traccar.Repo, log zerolog.Logger) (*Emitter, error) {
-	subLogger := log.With().Str("source", "emitter").Logger()
-	encConn, err := nats.NewEncodedConn(conn, nats.JSON_ENCODER)
-	if err != nil {
-		return nil, err
-	}
+type EventEvent struct {
+	Action string             `json:"action"`
+	Event  model.TraccarEvent `json:"data"`
+}
 
-	return &Emitter{subLogger, repo, encConn}, nil
+// NewEmitter creates an emitter that sends changes to all sinks while elector says it's the leader.
+// Every position emitted is checked against geofences for crossings. Positions are sent at
+// least once, so the newest ones can be sent again when another replica takes over.
+func NewEmitter(repo *traccar.Repo, elector *Elector, geofences *GeofenceEvaluator, log zerolog.Logger, sinks ...Sink) *Emitter {
+	subLogger := log.With().Str("source", "emitter").Logger()
+	return &Emitter{log: subLogger, repo: repo, elector: elector, geofences: geofences, sinks: sinks}
 }
 
+// Run starts emitting in the background whenever this replica is the leader, till ctx is done.
 func (e *Emitter) Run(ctx context.Context, wg *sync.WaitGroup) {
 	// WaitGroup to force blocking on the caller
 	wg.Add(1)
 
+	go func() {
+		e.elector.Lead(ctx, e.emit)
+
+		e.log.Info().Msg("shutting down the emitter")
+
+		// flush whatever the sinks are holding on to
+		for _, sink := range e.sinks {
+			if err := sink.Close(); err != nil {
+				e.log.Err(err).Msg("failed to close sink")
+			}
+		}
+
+		// tell the caller we're done
+		wg.Done()
+	}()
+}
+
+// emit forwards database changes to the sinks till ctx is done
+func (e *Emitter) emit(ctx context.Context) {
+	// the last leader might have moved the checkpoint. Stalled till the first
+	// catch-up so it's retried if that fails.
+	e.checkpoint = 0
+	e.saved = 0
+	e.stalled = true
+	e.emitted = make(map[uint]bool)
+	e.newest = time.Time{}
+	defer e.saveCheckpoint()
+
 	out := make(chan []byte, 64)
+	connected := make(chan struct{})
 
 	// start listening for events
-	go e.repo.Listen(ctx, "tc_positions", out)
+	go e.repo.Listen(ctx, "tc_positions", out, connected)
 
-	go func() {
-	selectloop:
-		for {
-			select {
-			case ev := <-out:
-				var event PositionEvent
-				if err := json.Unmarshal(ev, &event); err != nil {
-					e.log.Err(err).RawJSON("event", ev).Msg("failed to to decode event")
-					continue
-				}
+	events := make(chan []byte, 64)
+	go e.repo.Listen(ctx, "tc_events", events, nil)
 
-				if event.Action != "INSERT" {
-					continue
-				}
+	devices := make(chan []byte, 64)
+	go e.repo.Listen(ctx, "tc_devices", devices, nil)
 
-				p := event.Position
+	if err := e.geofences.Load(ctx); err != nil {
+		e.log.Err(err).Msg("failed to load geofences")
+	}
 
-				res, err := traccar.TransformPosition(p)
-				if err != nil {
-					e.log.Err(err).Interface("position", p).Msg("")
-					continue
-				}
+	refresh := time.NewTicker(geofenceRefresh)
+	defer refresh.Stop()
+
+	save := time.NewTicker(checkpointInterval)
+	defer save.Stop()
+
+	// live positions don't move the checkpoint, catching up does once they've settled
+	sweep := time.NewTicker(settleDelay)
+	defer sweep.Stop()
+
+	for {
+		select {
+		case ev := <-out:
+			var event PositionEvent
+			if err := json.Unmarshal(ev, &event); err != nil {
+				e.log.Err(err).RawJSON("event", ev).Msg("failed to to decode event")
+				continue
+			}
+
+			if event.Action != "INSERT" {
+				continue
+			}
+
+			if e.emitted[event.Position.ID] {
+				continue
+			}
 
-				topic := fmt.Sprintf("traccar.positions.device_%d", res.Device)
-				if err := e.conn.Publish(topic, res); err != nil {
-					e.log.Err(err).Interface("position", res).Msg("failed to publish")
+			if err := e.emitPosition(ctx, event.Position); err != nil {
+				e.log.Err(err).Uint("position", event.Position.ID).Msg("failed to publish position")
+			}
+
+		case ev := <-events:
+			var event EventEvent
+			if err := json.Unmarshal(ev, &event); err != nil {
+				e.log.Err(err).RawJSON("event", ev).Msg("failed to to decode event")
+				continue
+			}
+
+			if event.Action != "INSERT" {
+				continue
+			}
+
+			e.emitEvent(ctx, event.Event)
+
+		case ev := <-devices:
+			var event DeviceEvent
+			if err := json.Unmarshal(ev, &event); err != nil {
+				e.log.Err(err).RawJSON("event", ev).Msg("failed to to decode event")
+				continue
+			}
+
+			e.emitDeviceChange(ctx, event)
+
+		case <-refresh.C:
+			if err := e.geofences.Load(ctx); err != nil {
+				e.log.Err(err).Msg("failed to refresh geofences")
+			}
+
+		case <-save.C:
+			if e.stalled {
+				if err := e.catchUp(ctx); err != nil {
+					e.log.Err(err).Msg("failed to catch up on missed positions")
 				}
+			}
 
-			case <-ctx.Done():
-				e.log.Info().Msg("shutting down the emitter")
+			e.saveCheckpoint()
+
+		case <-sweep.C:
+			if err := e.catchUp(ctx); err != nil {
+				e.log.Err(err).Msg("failed to catch up on missed positions")
+			}
+
+		case <-connected:
+			if err := e.catchUp(ctx); err != nil {
+				e.log.Err(err).Msg("failed to catch up on missed positions")
+			}
 
-				// draining the nats connection
-				if err := e.conn.Drain(); err != nil {
-					e.log.Err(err).Msg("failed to drain nats connection")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// catchUp emits every position after the checkpoint that hasn't been emitted yet, in
+// order, and moves the checkpoint past the ones that have settled. It stops at the first
+// position that fails to publish, leaving the emitter stalled.
+func (e *Emitter) catchUp(ctx context.Context) error {
+	if e.checkpoint == 0 {
+		id, err := e.repo.LoadCheckpoint(ctx, positionCheckpoint)
+		if err != nil {
+			return err
+		}
+		e.checkpoint = id
+		e.saved = id
+	}
+
+	e.stalled = false
+
+	// the checkpoint only moves over an unbroken run of settled positions
+	settled := true
+	after, count := e.checkpoint, 0
+
+	for {
+		ps, err := e.repo.PositionsAfter(ctx, after, catchUpBatch)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range ps {
+			after = p.ID
+			if p.CreatedAt.After(e.newest) {
+				e.newest = p.CreatedAt
+			}
+
+			if !e.emitted[p.ID] {
+				if err := e.emitPosition(ctx, e.repo.RemoveTZ(&p)); err != nil {
+					return err
 				}
+				count++
+			}
 
-				break selectloop
+			// any position with a lower ID has committed by now, so we've seen it
+			if settled = settled && !p.CreatedAt.After(e.newest.Add(-settleDelay)); settled {
+				e.checkpoint = p.ID
 			}
 		}
 
-		// tell the caller we're done
-		wg.Done()
-	}()
+		for id := range e.emitted {
+			if id <= e.checkpoint {
+				delete(e.emitted, id)
+			}
+		}
+
+		if len(ps) < catchUpBatch {
+			break
+		}
+	}
+
+	if count > 0 {
+		e.log.Info().Int("count", count).Uint("checkpoint", e.checkpoint).Msg("caught up on positions")
+	}
+
+	return nil
+}
+
+// emitPosition transforms and publishes p. A failed publish stalls the emitter so
+// catching up is retried.
+func (e *Emitter) emitPosition(ctx context.Context, p model.TraccarPosition) error {
+	res := traccar.TransformPosition(p)
+	if len(res.Warnings) > 0 {
+		e.log.Warn().Interface("warnings", res.Warnings).Uint("position", p.ID).Msg("could not read some attributes")
+	}
+
+	if err := e.publish(ctx, positionMessage(res)); err != nil {
+		e.stalled = true
+		return err
+	}
+
+	// positions at or before the checkpoint are only sent again after failing over
+	if p.ID > e.checkpoint {
+		e.emitted[p.ID] = true
+	}
+
+	if created := time.Time(p.CreatedAt); created.After(e.newest) {
+		e.newest = created
+	}
+
+	for _, c := range e.geofences.Evaluate(res) {
+		// crossings can't be replayed as the evaluator has moved on
+		e.publish(ctx, crossingMessage(c))
+	}
+
+	return nil
+}
+
+func positionMessage(p model.Position) Message {
+	return Message{
+		Subject: fmt.Sprintf("traccar.positions.device_%d", p.Device),
+		ID:      fmt.Sprintf("position-%d", p.ID),
+		Device:  p.Device,
+		Data:    p,
+	}
+}
+
+// emitEvent transforms and publishes a traccar event on a subject named after its type
+func (e *Emitter) emitEvent(ctx context.Context, ev model.TraccarEvent) {
+	res, err := traccar.TransformEvent(ev)
+	if err != nil {
+		e.log.Err(err).Uint("event", ev.ID).Str("type", string(ev.Type)).Msg("")
+		return
+	}
+
+	e.publish(ctx, eventMessage(res))
+}
+
+func eventMessage(ev model.Event) Message {
+	return Message{
+		Subject: fmt.Sprintf("traccar.events.%s.device_%d", ev.Type, ev.Device),
+		ID:      fmt.Sprintf("event-%d", ev.ID),
+		Device:  ev.Device,
+		Data:    ev,
+	}
+}
+
+// saveCheckpoint writes the checkpoint to the database if it has moved since the
+// last save. It runs on its own context so it can still save while shutting down.
+func (e *Emitter) saveCheckpoint() {
+	if e.checkpoint == e.saved {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), checkpointInterval)
+	defer cancel()
+
+	if err := e.repo.SaveCheckpoint(ctx, positionCheckpoint, e.checkpoint); err != nil {
+		e.log.Err(err).Uint("checkpoint", e.checkpoint).Msg("failed to save checkpoint")
+		return
+	}
+
+	e.saved = e.checkpoint
+}
+
+// emitDeviceChange publishes device lifecycle changes. Updates that don't touch
+// any of the fields we track (e.g. traccar moving positionid) are dropped.
+func (e *Emitter) emitDeviceChange(ctx context.Context, ev DeviceEvent) {
+	dev, err := traccar.TransformDevice(ev.Device)
+	if err != nil {
+		e.log.Err(err).Uint("device", ev.Device.ID).Msg("")
+		return
+	}
+
+	change := model.DeviceChange{Device: dev}
+
+	switch ev.Action {
+	case "INSERT":
+		change.Type = "device.created"
+	case "DELETE":
+		change.Type = "device.deleted"
+	case "UPDATE":
+		change.Type = "device.updated"
+
+		// consumers get updates without changes when we can't tell what changed,
+		// rather than missing renames and group moves
+		old, ok := e.previousDevice(ev)
+		if !ok {
+			break
+		}
+
+		if change.Changes = traccar.DiffDevices(old, dev); len(change.Changes) == 0 {
+			return
+		}
+	default:
+		return
+	}
+
+	e.publish(ctx, Message{
+		Subject: fmt.Sprintf("traccar.devices.%s.device_%d", strings.TrimPrefix(change.Type, "device."), dev.ID),
+		Device:  dev.ID,
+		Data:    change,
+	})
+}
+
+// previousDevice rebuilds the version of the device before an update from the
+// columns the update changed. They're left out of large notifications.
+func (e *Emitter) previousDevice(ev DeviceEvent) (model.Device, bool) {
+	if len(ev.Old) == 0 || string(ev.Old) == "null" {
+		e.log.Warn().Uint("device", ev.Device.ID).Msg("device update has no previous version")
+		return model.Device{}, false
+	}
+
+	prev := ev.Device
+	if err := json.Unmarshal(ev.Old, &prev); err != nil {
+		e.log.Err(err).Uint("device", ev.Device.ID).Msg("failed to decode previous version")
+		return model.Device{}, false
+	}
+
+	old, err := traccar.TransformDevice(prev)
+	if err != nil {
+		e.log.Err(err).Uint("device", ev.Device.ID).Msg("")
+		return model.Device{}, false
+	}
+
+	return old, true
+}
+
+// publish hands msg to every sink, logging the ones that fail. It returns the
+// first failure, after trying the rest of the sinks.
+func (e *Emitter) publish(ctx context.Context, msg Message) error {
+	var first error
+	for _, sink := range e.sinks {
+		if err := sink.Send(ctx, msg); err != nil {
+			e.log.Err(err).Str("subject", msg.Subject).Str("id", msg.ID).Msg("failed to publish")
+			if first == nil {
+				first = err
+			}
+		}
+	}
+
+	return first
 }
@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"tsaron.com/traccar-proxy/pkg/model"
+	"tsaron.com/traccar-proxy/pkg/traccar"
+)
+
+// how often the evaluator picks up geofence changes made through the API
+const geofenceRefresh = time.Minute
+
+// GeofenceEvaluator tracks which geofences each device is in, reporting when a position
+// takes a device across a boundary. It is not safe for concurrent use.
+type GeofenceEvaluator struct {
+	log    zerolog.Logger
+	repo   *traccar.Repo
+	fences []traccar.Geofence
+
+	// whether each device was last seen inside each geofence
+	inside map[uint]map[uint]bool
+}
+
+func NewGeofenceEvaluator(repo *traccar.Repo, log zerolog.Logger) *GeofenceEvaluator {
+	subLogger := log.With().Str("source", "geofences").Logger()
+	return &GeofenceEvaluator{log: subLogger, repo: repo, inside: make(map[uint]map[uint]bool)}
+}
+
+// Load refreshes the geofences being evaluated, forgetting the state of deleted ones.
+func (g *GeofenceEvaluator) Load(ctx context.Context) error {
+	fences, err := g.repo.Geofences(ctx)
+	if err != nil {
+		return err
+	}
+
+	exists := make(map[uint]bool)
+	for _, f := range fences {
+		exists[f.ID] = true
+	}
+
+	for _, state := range g.inside {
+		for id := range state {
+			if !exists[id] {
+				delete(state, id)
+			}
+		}
+	}
+
+	g.fences = fences
+
+	return nil
+}
+
+// Evaluate updates the state of the position's device and returns the crossings it caused.
+// The first position seen for a device/geofence pair only sets its state as we can't tell
+// where the device was before.
+func (g *GeofenceEvaluator) Evaluate(p model.Position) []model.GeofenceCrossing {
+	state, ok := g.inside[p.Device]
+	if !ok {
+		state = make(map[uint]bool)
+		g.inside[p.Device] = state
+	}
+
+	var crossings []model.GeofenceCrossing
+	for i := range g.fences {
+		f := &g.fences[i]
+
+		inside := f.Contains(p.Latitude, p.Longitude)
+		was, known := state[f.ID]
+		state[f.ID] = inside
+
+		if !known || was == inside {
+			continue
+		}
+
+		crossing := model.GeofenceCrossing{Geofence: traccar.TransformGeofence(f), Position: p}
+		if inside {
+			crossing.Type = "geofence.enter"
+		} else {
+			crossing.Type = "geofence.exit"
+		}
+		crossings = append(crossings, crossing)
+	}
+
+	return crossings
+}
+
+func crossingMessage(c model.GeofenceCrossing) Message {
+	action := strings.TrimPrefix(c.Type, "geofence.")
+
+	return Message{
+		Subject: fmt.Sprintf("traccar.geofences.%s.device_%d", action, c.Position.Device),
+		ID:      fmt.Sprintf("geofence-%d-%s-%d", c.Geofence.ID, action, c.Position.ID),
+		Device:  c.Position.Device,
+		Data:    c,
+	}
+}
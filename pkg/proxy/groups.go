@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"tsaron.com/traccar-proxy/pkg/traccar"
+)
+
+const (
+	// how long the group tree is used before it's reloaded. Traccar doesn't notify
+	// us of group changes.
+	groupTreeTTL = time.Minute
+	// how soon the group tree can be reloaded for a group it doesn't know
+	groupTreeRetry = 5 * time.Second
+)
+
+// GroupTree knows the parent of every group, so devices can be matched against
+// group filters that include subgroups like the REST API does.
+type GroupTree struct {
+	log  zerolog.Logger
+	repo *traccar.Repo
+
+	mu      sync.Mutex
+	parents map[uint]uint
+	loaded  time.Time
+}
+
+func NewGroupTree(repo *traccar.Repo, log zerolog.Logger) *GroupTree {
+	subLogger := log.With().Str("source", "group-tree").Logger()
+	return &GroupTree{log: subLogger, repo: repo}
+}
+
+// Ancestors returns group followed by every group above it. It's nil for devices
+// without a group.
+func (t *GroupTree) Ancestors(ctx context.Context, group uint) []uint {
+	if group == 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	age := time.Since(t.loaded)
+	if _, ok := t.parents[group]; age > groupTreeTTL || (!ok && age > groupTreeRetry) {
+		t.load(ctx)
+	}
+
+	return ancestors(t.parents, group)
+}
+
+// load replaces the tree from the database, keeping the old one if that fails
+func (t *GroupTree) load(ctx context.Context) {
+	// don't retry on every message while the database is down
+	t.loaded = time.Now()
+
+	groups, err := t.repo.Groups(ctx)
+	if err != nil {
+		t.log.Err(err).Msg("failed to load groups")
+		return
+	}
+
+	parents := make(map[uint]uint, len(groups))
+	for _, g := range groups {
+		parents[g.ID] = g.Parent
+	}
+	t.parents = parents
+}
+
+// ancestors walks up parents from group. It stops at groups it doesn't know and
+// at cycles, which traccar doesn't prevent.
+func ancestors(parents map[uint]uint, group uint) []uint {
+	chain := []uint{group}
+
+	for len(chain) <= len(parents) {
+		parent, ok := parents[chain[len(chain)-1]]
+		if !ok || parent == 0 || containsID(chain, parent) {
+			break
+		}
+		chain = append(chain, parent)
+	}
+
+	return chain
+}
+
+func containsID(ids []uint, id uint) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+
+	return false
+}
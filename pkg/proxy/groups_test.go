@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAncestors(t *testing.T) {
+	parents := map[uint]uint{
+		1: 0,
+		2: 1,
+		3: 2,
+		4: 5,
+		5: 4,
+		6: 6,
+	}
+
+	tests := []struct {
+		name  string
+		group uint
+		want  []uint
+	}{
+		{"root", 1, []uint{1}},
+		{"nested", 3, []uint{3, 2, 1}},
+		{"unknown", 9, []uint{9}},
+		{"cycle", 4, []uint{4, 5}},
+		{"own parent", 6, []uint{6}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ancestors(parents, tt.group); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ancestors() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"tsaron.com/traccar-proxy/pkg/traccar"
+)
+
+// how many messages a subscriber can fall behind before we start dropping them
+const subscriptionBuffer = 64
+
+// Hub fans changes out to subscribers within this process, such as streaming API clients.
+// Unlike the emitter it runs on every replica.
+type Hub struct {
+	log   zerolog.Logger
+	repo  *traccar.Repo
+	cache *PositionCache
+	tree  *GroupTree
+
+	mu   sync.RWMutex
+	subs map[*Subscription]bool
+
+	// group of each device we've seen, kept fresh by device changes. Only
+	// touched by the Run loop.
+	groups map[uint]uint
+}
+
+// NewHub creates a hub that also keeps cache up to date with new positions
+func NewHub(repo *traccar.Repo, cache *PositionCache, tree *GroupTree, log zerolog.Logger) *Hub {
+	subLogger := log.With().Str("source", "hub").Logger()
+	return &Hub{
+		log:    subLogger,
+		repo:   repo,
+		cache:  cache,
+		tree:   tree,
+		subs:   make(map[*Subscription]bool),
+		groups: make(map[uint]uint),
+	}
+}
+
+// Run listens for new positions and events and broadcasts them till ctx is done, at
+// which point every subscription is closed.
+func (h *Hub) Run(ctx context.Context, wg *sync.WaitGroup) {
+	// WaitGroup to force blocking on the caller
+	wg.Add(1)
+
+	positions := make(chan []byte, 64)
+	connected := make(chan struct{})
+	go h.repo.Listen(ctx, "tc_positions", positions, connected)
+
+	events := make(chan []byte, 64)
+	go h.repo.Listen(ctx, "tc_events", events, nil)
+
+	devices := make(chan []byte, 64)
+	go h.repo.Listen(ctx, "tc_devices", devices, nil)
+
+	go func() {
+	selectloop:
+		for {
+			select {
+			case ev := <-positions:
+				var event PositionEvent
+				if err := json.Unmarshal(ev, &event); err != nil {
+					h.log.Err(err).RawJSON("event", ev).Msg("failed to to decode event")
+					continue
+				}
+
+				if event.Action != "INSERT" {
+					continue
+				}
+
+				res := traccar.TransformPosition(event.Position)
+				h.cache.Update(res)
+				h.broadcast(ctx, positionMessage(res))
+
+			case ev := <-events:
+				var event EventEvent
+				if err := json.Unmarshal(ev, &event); err != nil {
+					h.log.Err(err).RawJSON("event", ev).Msg("failed to to decode event")
+					continue
+				}
+
+				if event.Action != "INSERT" {
+					continue
+				}
+
+				res, err := traccar.TransformEvent(event.Event)
+				if err != nil {
+					h.log.Err(err).Uint("event", event.Event.ID).Str("type", string(event.Event.Type)).Msg("")
+					continue
+				}
+
+				h.broadcast(ctx, eventMessage(res))
+
+			case ev := <-devices:
+				var event DeviceEvent
+				if err := json.Unmarshal(ev, &event); err != nil {
+					h.log.Err(err).RawJSON("event", ev).Msg("failed to to decode event")
+					continue
+				}
+
+				if event.Action == "DELETE" {
+					delete(h.groups, event.Device.ID)
+				} else {
+					h.groups[event.Device.ID] = event.Device.Group
+				}
+
+			case <-connected:
+				// we might have missed changes while the listener was down. Warming up
+				// after listening starts means we don't miss positions in between.
+				h.groups = make(map[uint]uint)
+				go func() {
+					if err := h.cache.Warm(ctx); err != nil {
+						h.log.Err(err).Msg("failed to warm position cache")
+					}
+				}()
+
+			case <-ctx.Done():
+				h.log.Info().Msg("shutting down the hub")
+				h.closeAll()
+
+				break selectloop
+			}
+		}
+
+		// tell the caller we're done
+		wg.Done()
+	}()
+}
+
+// Subscribe creates a subscription for messages about the given devices
+func (h *Hub) Subscribe(devices ...uint) *Subscription {
+	ch := make(chan Message, subscriptionBuffer)
+	sub := &Subscription{
+		C:       ch,
+		ch:      ch,
+		hub:     h,
+		devices: make(map[uint]bool),
+		groups:  make(map[uint]bool),
+	}
+	sub.Add(devices...)
+
+	h.mu.Lock()
+	h.subs[sub] = true
+	h.mu.Unlock()
+
+	return sub
+}
+
+func (h *Hub) broadcast(ctx context.Context, msg Message) {
+	groups := h.tree.Ancestors(ctx, h.groupOf(ctx, msg.Device))
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for sub := range h.subs {
+		if !sub.wants(msg.Device, groups) {
+			continue
+		}
+
+		select {
+		case sub.ch <- msg:
+		default:
+			h.log.Warn().Str("subject", msg.Subject).Msg("dropped message for slow subscriber")
+		}
+	}
+}
+
+func (h *Hub) groupOf(ctx context.Context, device uint) uint {
+	if group, ok := h.groups[device]; ok {
+		return group
+	}
+
+	dev, err := h.repo.FindDeviceByID(ctx, device)
+	if err != nil {
+		h.log.Err(err).Uint("device", device).Msg("could not find device group")
+		return 0
+	}
+
+	// the device might have been deleted already
+	if dev == nil {
+		return 0
+	}
+
+	h.groups[device] = dev.Group
+
+	return dev.Group
+}
+
+func (h *Hub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs {
+		delete(h.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// Subscription receives messages about a set of devices or device groups on C
+// until it is closed
+type Subscription struct {
+	C <-chan Message
+
+	ch  chan Message
+	hub *Hub
+
+	mu      sync.RWMutex
+	devices map[uint]bool
+	groups  map[uint]bool
+}
+
+// Add starts receiving messages for the given devices
+func (s *Subscription) Add(devices ...uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range devices {
+		s.devices[d] = true
+	}
+}
+
+// Remove stops receiving messages for the given devices
+func (s *Subscription) Remove(devices ...uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range devices {
+		delete(s.devices, d)
+	}
+}
+
+// AddGroups starts receiving messages for every device in the given groups and
+// their subgroups
+func (s *Subscription) AddGroups(groups ...uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, g := range groups {
+		s.groups[g] = true
+	}
+}
+
+// RemoveGroups stops receiving messages for the given groups. Devices added
+// individually are left alone.
+func (s *Subscription) RemoveGroups(groups ...uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, g := range groups {
+		delete(s.groups, g)
+	}
+}
+
+// Close stops the subscription and closes C. It's safe to call after the hub has shut down.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	if s.hub.subs[s] {
+		delete(s.hub.subs, s)
+		close(s.ch)
+	}
+}
+
+// wants checks device along with its group and every group above it
+func (s *Subscription) wants(device uint, groups []uint) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.devices[device] {
+		return true
+	}
+
+	for _, g := range groups {
+		if s.groups[g] {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/rs/zerolog"
+)
+
+// how often followers try to take the lock and leaders check they still hold it
+const electionInterval = 10 * time.Second
+
+// Elector uses a postgres advisory lock to make sure only one replica does a job at a time.
+// The lock lives as long as the session holding it, so a crashed leader gives it up
+// as soon as postgres notices the connection is gone.
+type Elector struct {
+	log zerolog.Logger
+	db  *pg.DB
+	key int64
+}
+
+func NewElector(db *pg.DB, key int64, log zerolog.Logger) *Elector {
+	subLogger := log.With().Str("source", "elector").Int64("lock", key).Logger()
+	return &Elector{subLogger, db, key}
+}
+
+// Lead blocks till ctx is done, calling run every time this replica becomes the leader.
+// The context passed to run is cancelled as soon as leadership is lost.
+func (e *Elector) Lead(ctx context.Context, run func(ctx context.Context)) {
+	for {
+		conn := e.acquire(ctx)
+		if conn == nil {
+			return
+		}
+		e.log.Info().Msg("acquired leadership")
+
+		leadCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			run(leadCtx)
+			close(done)
+		}()
+
+		e.hold(leadCtx, conn, done)
+		cancel()
+		<-done
+
+		e.release(conn)
+		if ctx.Err() != nil {
+			return
+		}
+		e.log.Warn().Msg("lost leadership")
+	}
+}
+
+// acquire waits till it can take the lock, returning the connection that holds it. It
+// returns nil if ctx is done first.
+func (e *Elector) acquire(ctx context.Context) *pg.Conn {
+	for {
+		conn := e.db.Conn()
+
+		var ok bool
+		_, err := conn.QueryOneContext(ctx, pg.Scan(&ok), "SELECT pg_try_advisory_lock(?)", e.key)
+		if err == nil && ok {
+			return conn
+		}
+
+		if err != nil && ctx.Err() == nil {
+			e.log.Err(err).Msg("failed to request lock")
+		}
+		_ = conn.Close()
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(electionInterval):
+		}
+	}
+}
+
+// hold makes sure the session holding the lock stays alive till ctx or done is closed
+func (e *Elector) hold(ctx context.Context, conn *pg.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(electionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case <-ticker.C:
+			// a reconnected session would not have the lock anymore
+			var held bool
+			_, err := conn.QueryOneContext(ctx, pg.Scan(&held), `
+				SELECT EXISTS (
+					SELECT 1 FROM pg_locks
+					WHERE locktype = 'advisory' AND granted AND pid = pg_backend_pid()
+					AND ((classid::bigint << 32) | objid::bigint) = ?
+				)`, e.key)
+			if err != nil || !held {
+				e.log.Err(err).Msg("lost the lock")
+				return
+			}
+		}
+	}
+}
+
+func (e *Elector) release(conn *pg.Conn) {
+	if _, err := conn.Exec("SELECT pg_advisory_unlock(?)", e.key); err != nil {
+		e.log.Err(err).Msg("failed to release lock")
+	}
+
+	if err := conn.Close(); err != nil {
+		e.log.Err(err).Msg("failed to close lock connection")
+	}
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// how many times we try publishing to JetStream before giving up on a message
+	publishAttempts = 5
+	// base delay between JetStream publish attempts. It grows linearly with each attempt
+	publishBackoff = 500 * time.Millisecond
+)
+
+// NATSSink publishes messages on their subject on NATS
+type NATSSink struct {
+	log  zerolog.Logger
+	conn *nats.EncodedConn
+	js   nats.JetStreamContext
+}
+
+// NewNATSSink creates a sink that publishes on conn. When js is not nil, messages are
+// published on JetStream with acks and deduplication instead of core NATS.
+func NewNATSSink(conn *nats.Conn, js nats.JetStreamContext, log zerolog.Logger) (*NATSSink, error) {
+	subLogger := log.With().Str("source", "nats-sink").Logger()
+	encConn, err := nats.NewEncodedConn(conn, nats.JSON_ENCODER)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NATSSink{subLogger, encConn, js}, nil
+}
+
+// Send publishes msg. In JetStream mode the message ID (when set) is used for deduplication
+// and the publish is retried until it is acknowledged.
+func (s *NATSSink) Send(ctx context.Context, msg Message) error {
+	if s.js == nil {
+		return s.conn.Publish(msg.Subject, msg.Data)
+	}
+
+	data, err := json.Marshal(msg.Data)
+	if err != nil {
+		return err
+	}
+
+	var opts []nats.PubOpt
+	if msg.ID != "" {
+		opts = append(opts, nats.MsgId(msg.ID))
+	}
+
+	for attempt := 1; ; attempt++ {
+		if _, err = s.js.Publish(msg.Subject, data, opts...); err == nil {
+			return nil
+		}
+
+		if attempt == publishAttempts {
+			return err
+		}
+
+		s.log.Warn().Err(err).Str("topic", msg.Subject).Int("attempt", attempt).Msg("retrying publish")
+
+		select {
+		case <-time.After(time.Duration(attempt) * publishBackoff):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// Close drains the nats connection
+func (s *NATSSink) Close() error {
+	return s.conn.Drain()
+}
@@ -0,0 +1,23 @@
+package proxy
+
+import "context"
+
+// Message is a single change the emitter wants delivered
+type Message struct {
+	// Subject describes what the message is about e.g. traccar.positions.device_1
+	Subject string
+	// ID uniquely identifies the message for deduplication. It can be empty.
+	ID string
+	// Device is the traccar device the message is about
+	Device uint
+	Data   interface{}
+}
+
+// Sink is a destination for the messages produced by the emitter
+type Sink interface {
+	// Send delivers msg or fails with the reason it couldn't. Sinks that deliver
+	// asynchronously only fail when they can't accept msg.
+	Send(ctx context.Context, msg Message) error
+	// Close flushes pending messages and releases the sink's resources
+	Close() error
+}
@@ -0,0 +1,273 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"tsaron.com/traccar-proxy/pkg/model"
+	"tsaron.com/traccar-proxy/pkg/traccar"
+)
+
+const (
+	// how many times a webhook delivery is attempted before it's dead-lettered
+	webhookAttempts = 6
+	// delay before the first retry. It doubles on every attempt after that
+	webhookBackoff = time.Second
+	// the longest we'll wait between two attempts
+	webhookMaxBackoff = time.Minute
+	// how many messages can wait on a single target
+	webhookQueueSize = 256
+	// how long a single delivery attempt can take
+	webhookTimeout = 10 * time.Second
+)
+
+// WebhookTarget is a URL that receives messages for devices in a group or any of
+// its subgroups. A zero group means the target gets messages for every device.
+type WebhookTarget struct {
+	Group uint   `json:"group"`
+	URL   string `json:"url"`
+}
+
+// LoadWebhookTargets reads the JSON list of webhook targets in the file at path
+func LoadWebhookTargets(path string) ([]WebhookTarget, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []WebhookTarget
+	if err := json.Unmarshal(raw, &targets); err != nil {
+		return nil, errors.Wrap(err, "could not decode webhook targets")
+	}
+
+	for _, t := range targets {
+		if t.URL == "" {
+			return nil, errors.Errorf("webhook target for group %d has no URL", t.Group)
+		}
+	}
+
+	return targets, nil
+}
+
+type webhookDelivery struct {
+	msg  Message
+	body []byte
+}
+
+// WebhookSink POSTs messages to HTTP targets based on the group of the device they are about.
+// Bodies are signed with HMAC-SHA256 in the X-Signature header. Deliveries are retried with
+// exponential backoff and end up in the dead letter table when they keep failing.
+type WebhookSink struct {
+	log    zerolog.Logger
+	repo   *traccar.Repo
+	tree   *GroupTree
+	client *http.Client
+	secret []byte
+
+	targets []WebhookTarget
+	queues  []chan webhookDelivery
+	workers *sync.WaitGroup
+	stop    chan struct{}
+
+	// group of each device we've seen, kept fresh by device changes
+	mu     sync.Mutex
+	groups map[uint]uint
+}
+
+func NewWebhookSink(repo *traccar.Repo, tree *GroupTree, secret []byte, targets []WebhookTarget, log zerolog.Logger) *WebhookSink {
+	s := &WebhookSink{
+		log:     log.With().Str("source", "webhook-sink").Logger(),
+		repo:    repo,
+		tree:    tree,
+		client:  &http.Client{Timeout: webhookTimeout},
+		secret:  secret,
+		targets: targets,
+		workers: new(sync.WaitGroup),
+		stop:    make(chan struct{}),
+		groups:  make(map[uint]uint),
+	}
+
+	// a worker per target so a slow target can't hold up the others
+	for i := range targets {
+		q := make(chan webhookDelivery, webhookQueueSize)
+		s.queues = append(s.queues, q)
+
+		s.workers.Add(1)
+		go s.work(targets[i], q)
+	}
+
+	return s
+}
+
+// Send queues msg for every target interested in its device's group
+func (s *WebhookSink) Send(ctx context.Context, msg Message) error {
+	group, err := s.deviceGroup(ctx, msg)
+	if err != nil {
+		return errors.Wrap(err, "could not find device group")
+	}
+
+	body, err := json.Marshal(msg.Data)
+	if err != nil {
+		return err
+	}
+
+	groups := s.tree.Ancestors(ctx, group)
+
+	for i, t := range s.targets {
+		if t.Group != 0 && !containsID(groups, t.Group) {
+			continue
+		}
+
+		select {
+		case s.queues[i] <- webhookDelivery{msg, body}:
+		default:
+			s.deadLetter(t, webhookDelivery{msg, body}, 0, errors.New("webhook queue is full"))
+		}
+	}
+
+	return nil
+}
+
+// Close waits for queued deliveries to be attempted once. Anything that still
+// fails is dead-lettered rather than retried.
+func (s *WebhookSink) Close() error {
+	close(s.stop)
+	for _, q := range s.queues {
+		close(q)
+	}
+	s.workers.Wait()
+
+	return nil
+}
+
+func (s *WebhookSink) deviceGroup(ctx context.Context, msg Message) (uint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// device changes tell us the latest group before anyone else
+	if change, ok := msg.Data.(model.DeviceChange); ok {
+		if change.Type == "device.deleted" {
+			delete(s.groups, msg.Device)
+		} else {
+			s.groups[msg.Device] = change.Device.Group
+		}
+
+		return change.Device.Group, nil
+	}
+
+	if group, ok := s.groups[msg.Device]; ok {
+		return group, nil
+	}
+
+	dev, err := s.repo.FindDeviceByID(ctx, msg.Device)
+	if err != nil {
+		return 0, err
+	}
+
+	// the device might have been deleted already
+	if dev == nil {
+		return 0, nil
+	}
+
+	s.groups[msg.Device] = dev.Group
+
+	return dev.Group, nil
+}
+
+func (s *WebhookSink) work(t WebhookTarget, q <-chan webhookDelivery) {
+	defer s.workers.Done()
+
+	for d := range q {
+		s.deliver(t, d)
+	}
+}
+
+func (s *WebhookSink) deliver(t WebhookTarget, d webhookDelivery) {
+	backoff := webhookBackoff
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = s.post(t.URL, d); err == nil {
+			return
+		}
+
+		if attempt == webhookAttempts {
+			s.deadLetter(t, d, attempt, err)
+			return
+		}
+
+		s.log.Warn().Err(err).Str("url", t.URL).Int("attempt", attempt).Msg("retrying webhook")
+
+		select {
+		case <-time.After(backoff):
+		case <-s.stop:
+			s.deadLetter(t, d, attempt, err)
+			return
+		}
+
+		if backoff *= 2; backoff > webhookMaxBackoff {
+			backoff = webhookMaxBackoff
+		}
+	}
+}
+
+func (s *WebhookSink) post(url string, d webhookDelivery) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(d.body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Subject", d.msg.Subject)
+	req.Header.Set("X-Message-Id", d.msg.ID)
+	req.Header.Set("X-Signature", "sha256="+s.sign(d.body))
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// make sure the connection can be reused
+	_, _ = ioutil.ReadAll(res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+func (s *WebhookSink) sign(body []byte) string {
+	mac := hmac.New(sha256.New, s.secret)
+	_, _ = mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *WebhookSink) deadLetter(t WebhookTarget, d webhookDelivery, attempts int, reason error) {
+	s.log.Err(reason).Str("url", t.URL).Str("subject", d.msg.Subject).Msg("dead-lettering webhook")
+
+	err := s.repo.SaveDeadLetter(context.Background(), &traccar.DeadLetter{
+		URL:       t.URL,
+		Subject:   d.msg.Subject,
+		MessageID: d.msg.ID,
+		Body:      string(d.body),
+		Error:     reason.Error(),
+		Attempts:  attempts,
+	})
+	if err != nil {
+		s.log.Err(err).Str("url", t.URL).RawJSON("body", d.body).Msg("failed to save dead letter")
+	}
+}
@@ -0,0 +1,42 @@
+package rest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/tsaron/anansi"
+)
+
+// page is a list of results along with the cursor for the next page. NextCursor is
+// empty on the last page.
+type page struct {
+	Items      interface{} `json:"items"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// encodeCursor hides the details of a cursor from clients
+func encodeCursor(v interface{}) string {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeCursor reads a cursor created by encodeCursor into v
+func decodeCursor(cursor string, v interface{}) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(raw, v)
+	}
+
+	if err != nil {
+		panic(anansi.APIError{
+			Code:    http.StatusBadRequest,
+			Message: "The cursor is invalid",
+			Err:     err,
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package rest
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/tsaron/anansi"
+	"tsaron.com/traccar-proxy/pkg/traccar"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	want := traccar.PositionCursor{RecordedAt: time.Date(2021, 3, 1, 10, 30, 15, 123456000, time.UTC), ID: 42}
+
+	var got traccar.PositionCursor
+	decodeCursor(encodeCursor(want), &got)
+
+	if got.ID != want.ID || !got.RecordedAt.Equal(want.RecordedAt) {
+		t.Errorf("decodeCursor() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeInvalidCursor(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "not a cursor!"},
+		{"padded base64", "e30="},
+		{"not JSON", encodeCursor("x")[:3]},
+		{"wrong shape", encodeCursor([]int{1, 2})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				err, ok := recover().(anansi.APIError)
+				if !ok || err.Code != http.StatusBadRequest {
+					t.Errorf("decodeCursor(%q) should fail with a bad request, got %v", tt.cursor, err)
+				}
+			}()
+
+			decodeCursor(tt.cursor, new(traccar.PositionCursor))
+		})
+	}
+}
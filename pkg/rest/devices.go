@@ -1,21 +1,213 @@
 package rest
 
 import (
+	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi"
+	ozzo "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pkg/errors"
 	"github.com/tsaron/anansi"
 	"tsaron.com/traccar-proxy/pkg/model"
 	"tsaron.com/traccar-proxy/pkg/traccar"
 )
 
-func Devices(r *chi.Mux, sessions *anansi.SessionStore, repo *traccar.Repo) {
+const maxDevicePage = 500
+
+type deviceQuery struct {
+	Name     string `key:"name"`
+	Category string `key:"category"`
+	Model    string `key:"model"`
+	Group    uint   `key:"group"`
+	Disabled *bool  `key:"disabled"`
+	Online   *bool  `key:"online"`
+	Sort     string `key:"sort" default:"name"`
+	Order    string `key:"order" default:"asc"`
+	Cursor   string `key:"cursor"`
+	Limit    int    `key:"limit" default:"50"`
+}
+
+// deviceRequest is the body for creating a device
+type deviceRequest struct {
+	Name       string                 `json:"name"`
+	ExternalID string                 `json:"external_id"`
+	Phone      string                 `json:"phone"`
+	Model      string                 `json:"model"`
+	Contact    string                 `json:"contact"`
+	Category   string                 `json:"category"`
+	Group      uint                   `json:"group_id"`
+	Disabled   bool                   `json:"disabled"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+func (d deviceRequest) Validate() error {
+	return ozzo.ValidateStruct(&d,
+		ozzo.Field(&d.Name, ozzo.Required, ozzo.Length(1, 128)),
+		ozzo.Field(&d.ExternalID, ozzo.Required, ozzo.Length(1, 128)),
+		ozzo.Field(&d.Phone, ozzo.Length(0, 128)),
+		ozzo.Field(&d.Model, ozzo.Length(0, 128)),
+		ozzo.Field(&d.Contact, ozzo.Length(0, 512)),
+		ozzo.Field(&d.Category, ozzo.Length(0, 128)),
+	)
+}
+
+func (d deviceRequest) device() *traccar.Device {
+	return &traccar.Device{
+		Name:       d.Name,
+		ExternalID: d.ExternalID,
+		Phone:      d.Phone,
+		Model:      d.Model,
+		Contact:    d.Contact,
+		Category:   d.Category,
+		Group:      d.Group,
+		Disabled:   d.Disabled,
+		Payload:    encodeAttributes(d.Attributes),
+	}
+}
+
+// devicePatch is the body for updating a device. Only the fields that are set get changed.
+type devicePatch struct {
+	Name       *string                `json:"name"`
+	ExternalID *string                `json:"external_id"`
+	Phone      *string                `json:"phone"`
+	Model      *string                `json:"model"`
+	Contact    *string                `json:"contact"`
+	Category   *string                `json:"category"`
+	Group      *uint                  `json:"group_id"`
+	Disabled   *bool                  `json:"disabled"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+func (d devicePatch) Validate() error {
+	return ozzo.ValidateStruct(&d,
+		ozzo.Field(&d.Name, ozzo.NilOrNotEmpty, ozzo.Length(1, 128)),
+		ozzo.Field(&d.ExternalID, ozzo.NilOrNotEmpty, ozzo.Length(1, 128)),
+		ozzo.Field(&d.Phone, ozzo.Length(0, 128)),
+		ozzo.Field(&d.Model, ozzo.Length(0, 128)),
+		ozzo.Field(&d.Contact, ozzo.Length(0, 512)),
+		ozzo.Field(&d.Category, ozzo.Length(0, 128)),
+	)
+}
+
+func (d devicePatch) apply(dev *traccar.Device) {
+	for _, f := range []struct {
+		from *string
+		to   *string
+	}{
+		{d.Name, &dev.Name},
+		{d.ExternalID, &dev.ExternalID},
+		{d.Phone, &dev.Phone},
+		{d.Model, &dev.Model},
+		{d.Contact, &dev.Contact},
+		{d.Category, &dev.Category},
+	} {
+		if f.from != nil {
+			*f.to = *f.from
+		}
+	}
+
+	if d.Group != nil {
+		dev.Group = *d.Group
+	}
+
+	if d.Disabled != nil {
+		dev.Disabled = *d.Disabled
+	}
+
+	if d.Attributes != nil {
+		dev.Payload = encodeAttributes(d.Attributes)
+	}
+}
+
+// encodeAttributes converts attributes to the JSON traccar stores
+func encodeAttributes(attr map[string]interface{}) string {
+	if attr == nil {
+		return "{}"
+	}
+
+	raw, err := json.Marshal(attr)
+	if err != nil {
+		panic(err)
+	}
+
+	if len(raw) > 4000 {
+		panic(anansi.APIError{
+			Code:    http.StatusUnprocessableEntity,
+			Message: "attributes must be at most 4000 characters as JSON",
+		})
+	}
+
+	return string(raw)
+}
+
+// Devices registers the device routes. Devices that haven't reported in the last
+// onlineTimeout are considered offline.
+func Devices(r *chi.Mux, sessions *anansi.SessionStore, repo *traccar.Repo, onlineTimeout time.Duration) {
 	r.Route("/devices", func(r chi.Router) {
-		r.With(sessions.Headless()).Get("/{externalID}", getDevice(repo))
+		r.With(sessions.Headless()).Get("/", getDevices(repo, onlineTimeout))
+		r.With(sessions.Headless()).Post("/", createDevice(repo, onlineTimeout))
+		r.With(sessions.Headless()).Get("/{externalID}", getDevice(repo, onlineTimeout))
+		r.With(sessions.Headless()).Patch("/{externalID}", updateDevice(repo, onlineTimeout))
+		r.With(sessions.Headless()).Delete("/{externalID}", deleteDevice(repo))
 	})
 }
 
-func getDevice(repo *traccar.Repo) http.HandlerFunc {
+func getDevices(repo *traccar.Repo, onlineTimeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := new(deviceQuery)
+		anansi.ReadQuery(r, q)
+
+		anansi.SendSuccess(r, w, listDevices(r, repo, q, onlineTimeout))
+	}
+}
+
+// listDevices loads the page of devices q asks for
+func listDevices(r *http.Request, repo *traccar.Repo, q *deviceQuery, onlineTimeout time.Duration) page {
+	if q.Limit <= 0 || q.Limit > maxDevicePage {
+		panic(anansi.APIError{
+			Code:    http.StatusBadRequest,
+			Message: "limit must be between 1 and 500",
+		})
+	}
+
+	dq := traccar.DeviceQuery{
+		Name:        q.Name,
+		Category:    q.Category,
+		Model:       q.Model,
+		Group:       q.Group,
+		Disabled:    q.Disabled,
+		Online:      q.Online,
+		OnlineSince: time.Now().Add(-onlineTimeout),
+		Sort:        q.Sort,
+		Order:       q.Order,
+		Limit:       q.Limit,
+	}
+
+	if q.Cursor != "" {
+		dq.After = new(traccar.DeviceCursor)
+		decodeCursor(q.Cursor, dq.After)
+	}
+
+	devs, next, err := repo.FindDevices(r.Context(), dq)
+	if err == traccar.ErrInvalidQuery {
+		panic(anansi.APIError{
+			Code:    http.StatusBadRequest,
+			Message: "sort must be one of id, name or last_update and order either asc or desc",
+		})
+	} else if err != nil {
+		panic(errors.Wrap(err, "could not get devices"))
+	}
+
+	res := page{Items: transformDevices(repo, devs, onlineTimeout)}
+	if next != nil {
+		res.NextCursor = encodeCursor(next)
+	}
+
+	return res
+}
+
+func getDevice(repo *traccar.Repo, onlineTimeout time.Duration) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		externalID := anansi.StringParam(r, "externalID")
 
@@ -25,16 +217,130 @@ func getDevice(repo *traccar.Repo) http.HandlerFunc {
 		}
 
 		if dev == nil {
-			panic(anansi.APIError{
-				Code:    http.StatusNotFound,
-				Message: "Could not find device with the given ID",
-			})
+			panic(errDeviceNotFound)
 		}
 
-		anansi.SendSuccess(r, w, model.Device{
-			ID:         dev.ID,
-			Name:       dev.Name,
-			ExternalID: dev.ExternalID,
+		anansi.SendSuccess(r, w, transformDevice(repo, dev, onlineTimeout))
+	}
+}
+
+// Traccar only picks up devices written here when it next refreshes its cache
+// (database.refreshDelay in its config)
+func createDevice(repo *traccar.Repo, onlineTimeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req deviceRequest
+		anansi.ReadJSON(r, &req)
+
+		dev := req.device()
+		if err := repo.CreateDevice(r.Context(), dev); err != nil {
+			panic(deviceWriteError(err, "could not create device"))
+		}
+
+		anansi.SendSuccess(r, w, transformDevice(repo, dev, onlineTimeout))
+	}
+}
+
+func updateDevice(repo *traccar.Repo, onlineTimeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req devicePatch
+		anansi.ReadJSON(r, &req)
+
+		dev, err := repo.FindDevice(r.Context(), anansi.StringParam(r, "externalID"))
+		if err != nil {
+			panic(errors.Wrap(err, "could not get device"))
+		}
+
+		if dev == nil {
+			panic(errDeviceNotFound)
+		}
+
+		req.apply(dev)
+
+		ok, err := repo.UpdateDevice(r.Context(), dev)
+		if err != nil {
+			panic(deviceWriteError(err, "could not update device"))
+		}
+
+		if !ok {
+			panic(errDeviceNotFound)
+		}
+
+		anansi.SendSuccess(r, w, transformDevice(repo, dev, onlineTimeout))
+	}
+}
+
+func deleteDevice(repo *traccar.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dev, err := repo.FindDevice(r.Context(), anansi.StringParam(r, "externalID"))
+		if err != nil {
+			panic(errors.Wrap(err, "could not get device"))
+		}
+
+		if dev == nil {
+			panic(errDeviceNotFound)
+		}
+
+		ok, err := repo.DeleteDevice(r.Context(), dev.ID)
+		if err != nil {
+			panic(errors.Wrap(err, "could not delete device"))
+		}
+
+		if !ok {
+			panic(errDeviceNotFound)
+		}
+
+		anansi.SendSuccess(r, w, nil)
+	}
+}
+
+// deviceWriteError converts errors from saving a device to ones the client can act on
+func deviceWriteError(err error, msg string) error {
+	switch err {
+	case traccar.ErrDuplicateDevice:
+		return anansi.APIError{
+			Code:    http.StatusConflict,
+			Message: "A device with the given external ID already exists",
+			Err:     err,
+		}
+	case traccar.ErrUnknownGroup:
+		return anansi.APIError{
+			Code:    http.StatusUnprocessableEntity,
+			Message: "Could not find group with the given ID",
+			Err:     err,
+		}
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
+
+var errDeviceNotFound = anansi.APIError{
+	Code:    http.StatusNotFound,
+	Message: "Could not find device with the given ID",
+}
+
+// transformDevice converts a device from traccar's format, marking whether it's online
+func transformDevice(repo *traccar.Repo, d *traccar.Device, onlineTimeout time.Duration) model.Device {
+	dev, err := traccar.TransformDevice(repo.RemoveDeviceTZ(d))
+	if err != nil {
+		panic(anansi.APIError{
+			Code:    http.StatusUnprocessableEntity,
+			Message: "Could not parse device because of attribute",
+			Err:     err,
+			Meta:    dev, // send this as it's still useful
 		})
 	}
+
+	online := dev.LastUpdate != nil && time.Since(*dev.LastUpdate) < onlineTimeout
+	dev.Online = &online
+
+	return dev
+}
+
+func transformDevices(repo *traccar.Repo, ds []traccar.Device, onlineTimeout time.Duration) []model.Device {
+	devs := []model.Device{}
+	for i := range ds {
+		devs = append(devs, transformDevice(repo, &ds[i], onlineTimeout))
+	}
+
+	return devs
 }
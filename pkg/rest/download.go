@@ -0,0 +1,200 @@
+package rest
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/tsaron/anansi"
+	"tsaron.com/traccar-proxy/pkg/model"
+	"tsaron.com/traccar-proxy/pkg/traccar"
+)
+
+const (
+	csvType    = "text/csv"
+	ndjsonType = "application/x-ndjson"
+)
+
+var downloadTypes = map[string]string{
+	"geojson": geoJSONType,
+	"csv":     csvType,
+	"ndjson":  ndjsonType,
+}
+
+// columns of position CSVs before the attributes
+var csvColumns = []string{
+	"id", "device_id", "recorded_at", "created_at", "valid",
+	"latitude", "longitude", "altitude", "speed", "course",
+}
+
+// attributeColumns are the JSON names of model.Attributes, which become CSV columns
+var attributeColumns = jsonNames(reflect.TypeOf(model.Attributes{}))
+
+// column of position CSVs after the attributes, holding attributes that couldn't be read as JSON
+const warningsColumn = "warnings"
+
+// downloadPositions streams every position matching opts in format (geojson, csv or ndjson)
+// without holding them in memory, compressing the response if the client accepts gzip.
+// Only geojson tracks can be simplified.
+func downloadPositions(w http.ResponseWriter, r *http.Request, repo *traccar.Repo, opts traccar.QueryOpts, format string, points bool, simplify traccar.SimplifyOpts) {
+	log := zerolog.Ctx(r.Context())
+
+	sent := &sentWriter{w: w}
+	var out io.Writer = sent
+
+	w.Header().Set("Content-Type", downloadTypes[format])
+
+	var gz *gzip.Writer
+	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		gz = gzip.NewWriter(sent)
+		out = gz
+	}
+
+	var err error
+	switch format {
+	case "geojson":
+		err = writeGeoJSON(out, r, repo, opts, points, simplify)
+	case "csv":
+		err = writeCSV(out, r, repo, opts)
+	case "ndjson":
+		err = writeNDJSON(out, r, repo, opts)
+	}
+
+	if err == nil && gz != nil {
+		err = gz.Close()
+	}
+
+	if err == nil {
+		return
+	}
+
+	// report errors normally if the client hasn't received anything yet, otherwise
+	// all they see is the response getting cut short.
+	if sent.sent {
+		log.Err(err).Msg("could not finish sending positions")
+		return
+	}
+
+	w.Header().Del("Content-Encoding")
+
+	if err == traccar.ErrInvalidQuery {
+		panic(anansi.APIError{
+			Code:    http.StatusBadRequest,
+			Message: "The position query is invalid",
+			Err:     err,
+		})
+	}
+
+	panic(errors.Wrap(err, "could not get positions"))
+}
+
+// sentWriter remembers whether anything has been written to the client
+type sentWriter struct {
+	w    io.Writer
+	sent bool
+}
+
+func (s *sentWriter) Write(b []byte) (int, error) {
+	s.sent = true
+	return s.w.Write(b)
+}
+
+// writeCSV writes positions as CSV rows with a column per attribute
+func writeCSV(w io.Writer, r *http.Request, repo *traccar.Repo, opts traccar.QueryOpts) error {
+	enc := csv.NewWriter(w)
+
+	header := append(append([]string{}, csvColumns...), attributeColumns...)
+	if err := enc.Write(append(header, warningsColumn)); err != nil {
+		return err
+	}
+
+	row := make([]string, len(header)+1)
+	err := repo.EachPosition(r.Context(), opts, func(tp *traccar.Position) error {
+		p := traccar.TransformPosition(repo.RemoveTZ(tp))
+
+		row = append(row[:0],
+			strconv.FormatUint(uint64(p.ID), 10),
+			strconv.FormatUint(uint64(p.Device), 10),
+			p.RecordedAt.Format(time.RFC3339),
+			p.CreatedAt.Format(time.RFC3339),
+			strconv.FormatBool(p.Valid),
+			csvFloat(p.Latitude),
+			csvFloat(p.Longitude),
+			csvFloat(p.Altitude),
+			csvFloat(p.Speed),
+			csvFloat(p.Course),
+		)
+
+		meta := reflect.ValueOf(p.Meta)
+		for i := range attributeColumns {
+			row = append(row, csvValue(meta.Field(i)))
+		}
+		row = append(row, csvValue(reflect.ValueOf(p.Warnings)))
+
+		return enc.Write(row)
+	})
+	if err != nil {
+		return err
+	}
+
+	enc.Flush()
+	return enc.Error()
+}
+
+// writeNDJSON writes positions as JSON objects, one per line
+func writeNDJSON(w io.Writer, r *http.Request, repo *traccar.Repo, opts traccar.QueryOpts) error {
+	enc := json.NewEncoder(w)
+
+	return repo.EachPosition(r.Context(), opts, func(tp *traccar.Position) error {
+		return enc.Encode(traccar.TransformPosition(repo.RemoveTZ(tp)))
+	})
+}
+
+// jsonNames lists the JSON names of the fields of struct type t in order
+func jsonNames(t reflect.Type) []string {
+	var names []string
+
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		names = append(names, name)
+	}
+
+	return names
+}
+
+func csvFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// csvValue formats an attribute, leaving it blank if it's not set. Extra attributes
+// differ between devices so they share a column as JSON, as do warnings.
+func csvValue(v reflect.Value) string {
+	if v.IsZero() {
+		return ""
+	}
+
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits())
+	case reflect.Map, reflect.Slice:
+		raw, _ := json.Marshal(v.Interface())
+		return string(raw)
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
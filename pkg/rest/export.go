@@ -0,0 +1,97 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/tsaron/anansi"
+	"tsaron.com/traccar-proxy/pkg/export"
+	"tsaron.com/traccar-proxy/pkg/model"
+	"tsaron.com/traccar-proxy/pkg/traccar"
+)
+
+type exportQuery struct {
+	Device uint      `key:"device"`
+	From   time.Time `key:"from"`
+	To     time.Time `key:"to"`
+	Format string    `key:"format" default:"gpx"`
+	// split the track into a segment per trip when set to "trips"
+	Split string `key:"split"`
+	// trip detection settings, see tripQuery
+	StopDuration int     `key:"stop_duration" default:"300"`
+	MinSpeed     float64 `key:"min_speed" default:"2"`
+	Ignition     bool    `key:"ignition"`
+	// track simplification, see positionQuery. Each trip is simplified on its own.
+	Tolerance   float64 `key:"tolerance"`
+	SampleEvery int     `key:"sample_every"`
+	MaxPoints   int     `key:"max_points"`
+}
+
+func exportPositions(repo *traccar.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := new(exportQuery)
+		anansi.ReadQuery(r, q)
+
+		if q.Format != "gpx" && q.Format != "kml" {
+			panic(anansi.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "format must be either gpx or kml",
+			})
+		}
+
+		if q.Split != "" && q.Split != "trips" {
+			panic(anansi.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "split can only be trips",
+			})
+		}
+
+		simplify := readSimplifyOpts(q.Tolerance, q.SampleEvery, q.MaxPoints)
+
+		ps := reportPositions(r, repo, q.Device, q.From, q.To)
+
+		dev, err := repo.FindDeviceByID(r.Context(), q.Device)
+		if err != nil {
+			panic(errors.Wrap(err, "could not get device"))
+		}
+
+		if dev == nil {
+			panic(errDeviceNotFound)
+		}
+
+		track := export.Track{Name: dev.Name, Device: dev.ID}
+		if q.Split == "trips" {
+			trips := traccar.DetectTrips(ps, traccar.TripOpts{
+				MinStopDuration: time.Duration(q.StopDuration) * time.Second,
+				SpeedThreshold:  q.MinSpeed,
+				UseIgnition:     q.Ignition,
+			})
+			track.Segments = export.SplitTrips(ps, trips)
+		} else if len(ps) > 0 {
+			track.Segments = [][]model.Position{ps}
+		}
+
+		for i, segment := range track.Segments {
+			track.Segments[i] = traccar.Simplify(segment, simplify)
+		}
+
+		name := fmt.Sprintf("%s %s", dev.Name, q.From.Format("2006-01-02"))
+		filename := fmt.Sprintf("device-%d-%s.%s", dev.ID, q.From.Format("20060102"), q.Format)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+		if q.Format == "gpx" {
+			w.Header().Set("Content-Type", export.GPXContentType)
+			err = export.WriteGPX(w, name, track)
+		} else {
+			w.Header().Set("Content-Type", export.KMLContentType)
+			err = export.WriteKML(w, name, track)
+		}
+
+		if err != nil {
+			zerolog.Ctx(r.Context()).Err(err).Msg("could not send export")
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	ozzo "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pkg/errors"
+	"github.com/tsaron/anansi"
+	"tsaron.com/traccar-proxy/pkg/model"
+	"tsaron.com/traccar-proxy/pkg/traccar"
+)
+
+type geofenceRequest struct {
+	Name      string        `json:"name"`
+	Type      string        `json:"type"`
+	Latitude  float64       `json:"latitude"`
+	Longitude float64       `json:"longitude"`
+	Radius    float64       `json:"radius"`
+	Points    []model.Point `json:"points"`
+}
+
+func (g geofenceRequest) Validate() error {
+	isCircle := g.Type == model.GeofenceCircle
+	isPolygon := g.Type == model.GeofencePolygon
+
+	return ozzo.ValidateStruct(&g,
+		ozzo.Field(&g.Name, ozzo.Required),
+		ozzo.Field(&g.Type, ozzo.Required, ozzo.In(model.GeofenceCircle, model.GeofencePolygon)),
+		ozzo.Field(&g.Latitude, ozzo.When(isCircle, ozzo.Min(-90.0), ozzo.Max(90.0))),
+		ozzo.Field(&g.Longitude, ozzo.When(isCircle, ozzo.Min(-180.0), ozzo.Max(180.0))),
+		ozzo.Field(&g.Radius, ozzo.When(isCircle, ozzo.Required, ozzo.Min(0.0))),
+		ozzo.Field(&g.Points, ozzo.When(isPolygon, ozzo.Required, ozzo.Length(3, 0))),
+	)
+}
+
+func (g geofenceRequest) geofence() *traccar.Geofence {
+	fence := &traccar.Geofence{Name: g.Name, Type: g.Type}
+
+	if g.Type == model.GeofenceCircle {
+		fence.Latitude = g.Latitude
+		fence.Longitude = g.Longitude
+		fence.Radius = g.Radius
+	} else {
+		fence.Points = g.Points
+	}
+
+	return fence
+}
+
+func Geofences(r *chi.Mux, sessions *anansi.SessionStore, repo *traccar.Repo) {
+	r.Route("/geofences", func(r chi.Router) {
+		r.Use(sessions.Headless())
+
+		r.Get("/", getGeofences(repo))
+		r.Post("/", createGeofence(repo))
+		r.Get("/{id}", getGeofence(repo))
+		r.Put("/{id}", updateGeofence(repo))
+		r.Delete("/{id}", deleteGeofence(repo))
+	})
+}
+
+func getGeofences(repo *traccar.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fences, err := repo.Geofences(r.Context())
+		if err != nil {
+			panic(errors.Wrap(err, "could not get geofences"))
+		}
+
+		res := []model.Geofence{}
+		for i := range fences {
+			res = append(res, traccar.TransformGeofence(&fences[i]))
+		}
+
+		anansi.SendSuccess(r, w, res)
+	}
+}
+
+func getGeofence(repo *traccar.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fence, err := repo.FindGeofence(r.Context(), anansi.IDParam(r, "id"))
+		if err != nil {
+			panic(errors.Wrap(err, "could not get geofence"))
+		}
+
+		if fence == nil {
+			panic(errGeofenceNotFound)
+		}
+
+		anansi.SendSuccess(r, w, traccar.TransformGeofence(fence))
+	}
+}
+
+func createGeofence(repo *traccar.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req geofenceRequest
+		anansi.ReadJSON(r, &req)
+
+		fence := req.geofence()
+		if err := repo.CreateGeofence(r.Context(), fence); err != nil {
+			panic(errors.Wrap(err, "could not create geofence"))
+		}
+
+		anansi.SendSuccess(r, w, traccar.TransformGeofence(fence))
+	}
+}
+
+func updateGeofence(repo *traccar.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req geofenceRequest
+		anansi.ReadJSON(r, &req)
+
+		fence := req.geofence()
+		fence.ID = anansi.IDParam(r, "id")
+
+		ok, err := repo.UpdateGeofence(r.Context(), fence)
+		if err != nil {
+			panic(errors.Wrap(err, "could not update geofence"))
+		}
+
+		if !ok {
+			panic(errGeofenceNotFound)
+		}
+
+		anansi.SendSuccess(r, w, traccar.TransformGeofence(fence))
+	}
+}
+
+func deleteGeofence(repo *traccar.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ok, err := repo.DeleteGeofence(r.Context(), anansi.IDParam(r, "id"))
+		if err != nil {
+			panic(errors.Wrap(err, "could not delete geofence"))
+		}
+
+		if !ok {
+			panic(errGeofenceNotFound)
+		}
+
+		anansi.SendSuccess(r, w, nil)
+	}
+}
+
+var errGeofenceNotFound = anansi.APIError{
+	Code:    http.StatusNotFound,
+	Message: "Could not find geofence with the given ID",
+}
@@ -0,0 +1,262 @@
+package rest
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tsaron.com/traccar-proxy/pkg/model"
+	"tsaron.com/traccar-proxy/pkg/traccar"
+)
+
+const geoJSONType = "application/geo+json"
+
+type trackProperties struct {
+	Kind      string    `json:"kind"`
+	Device    uint      `json:"device_id"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Positions int       `json:"positions"`
+}
+
+type pointProperties struct {
+	Kind       string    `json:"kind"`
+	ID         uint      `json:"id"`
+	Device     uint      `json:"device_id"`
+	RecordedAt time.Time `json:"recorded_at"`
+	Altitude   float64   `json:"altitude"`
+	Speed      float64   `json:"speed"`
+	Course     float64   `json:"course"`
+	model.Attributes
+}
+
+// writeGeoJSON streams the positions matching opts as a FeatureCollection with a track
+// per device. Every position is added as a point feature as well when points is set.
+func writeGeoJSON(w io.Writer, r *http.Request, repo *traccar.Repo, opts traccar.QueryOpts, points bool, simplify traccar.SimplifyOpts) error {
+	// tracks need the positions of each device to be together
+	opts.ByDevice = true
+
+	enc := &geoJSONWriter{w: bufio.NewWriterSize(w, 32*1024)}
+	enc.begin()
+
+	if simplify.Enabled() {
+		if err := writeSimplifiedTracks(enc, r, repo, opts, points, simplify); err != nil {
+			return err
+		}
+
+		enc.end()
+		return enc.err
+	}
+
+	err := repo.EachPosition(r.Context(), opts, func(p *traccar.Position) error {
+		enc.trackPoint(trackPosition(p))
+		return enc.err
+	})
+	if err != nil {
+		return err
+	}
+
+	if points {
+		enc.endTrack()
+
+		err = repo.EachPosition(r.Context(), opts, func(tp *traccar.Position) error {
+			enc.point(traccar.TransformPosition(repo.RemoveTZ(tp)))
+			return enc.err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	enc.end()
+	return enc.err
+}
+
+// writeSimplifiedTracks writes a simplified track for each device, followed by its points
+// when points is set. Simplifying needs a whole track so one device is held at a time.
+func writeSimplifiedTracks(enc *geoJSONWriter, r *http.Request, repo *traccar.Repo, opts traccar.QueryOpts, points bool, simplify traccar.SimplifyOpts) error {
+	var track []model.Position
+
+	flush := func() {
+		ps := traccar.Simplify(track, simplify)
+		for _, p := range ps {
+			enc.trackPoint(p)
+		}
+		enc.endTrack()
+
+		if points {
+			for _, p := range ps {
+				enc.point(p)
+			}
+		}
+
+		track = track[:0]
+	}
+
+	err := repo.EachPosition(r.Context(), opts, func(tp *traccar.Position) error {
+		if len(track) > 0 && track[0].Device != tp.Device {
+			flush()
+		}
+
+		track = append(track, traccar.TransformPosition(repo.RemoveTZ(tp)))
+		return enc.err
+	})
+	if err != nil {
+		return err
+	}
+
+	flush()
+	return enc.err
+}
+
+// trackPosition takes what tracks need from p without reading its attributes
+func trackPosition(p *traccar.Position) model.Position {
+	return model.Position{
+		ID:         p.ID,
+		Device:     p.Device,
+		RecordedAt: p.RecordedAt,
+		Latitude:   p.Latitude,
+		Longitude:  p.Longitude,
+		Altitude:   p.Altitude,
+	}
+}
+
+// geoJSONWriter writes a FeatureCollection one feature at a time. Tracks are written
+// as their positions come in, so only the first position of a track is held on to.
+type geoJSONWriter struct {
+	w        *bufio.Writer
+	err      error
+	features int
+
+	// the track being written
+	first model.Position
+	count int
+	to    time.Time
+}
+
+func (g *geoJSONWriter) write(s string) {
+	if g.err == nil {
+		_, g.err = g.w.WriteString(s)
+	}
+}
+
+func (g *geoJSONWriter) writeJSON(v interface{}) {
+	if g.err != nil {
+		return
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		g.err = err
+		return
+	}
+
+	_, g.err = g.w.Write(raw)
+}
+
+func (g *geoJSONWriter) coordinates(latitude, longitude, altitude float64) {
+	b := make([]byte, 0, 64)
+	b = append(b, '[')
+	b = strconv.AppendFloat(b, longitude, 'f', -1, 64)
+	b = append(b, ',')
+	b = strconv.AppendFloat(b, latitude, 'f', -1, 64)
+	b = append(b, ',')
+	b = strconv.AppendFloat(b, altitude, 'f', -1, 64)
+	b = append(b, ']')
+
+	g.write(string(b))
+}
+
+// startFeature separates features in the collection
+func (g *geoJSONWriter) startFeature() {
+	if g.features > 0 {
+		g.write(",")
+	}
+	g.features++
+}
+
+func (g *geoJSONWriter) begin() {
+	g.write(`{"type":"FeatureCollection","features":[`)
+}
+
+// trackPoint adds p to the track of its device, closing the track of the previous device
+func (g *geoJSONWriter) trackPoint(p model.Position) {
+	if g.count > 0 && p.Device != g.first.Device {
+		g.endTrack()
+	}
+
+	switch g.count {
+	case 0:
+		// wait for a second position before deciding on the geometry
+		g.first = p
+	case 1:
+		g.startFeature()
+		g.write(`{"type":"Feature","geometry":{"type":"LineString","coordinates":[`)
+		g.coordinates(g.first.Latitude, g.first.Longitude, g.first.Altitude)
+		g.write(",")
+		g.coordinates(p.Latitude, p.Longitude, p.Altitude)
+	default:
+		g.write(",")
+		g.coordinates(p.Latitude, p.Longitude, p.Altitude)
+	}
+
+	g.count++
+	g.to = p.RecordedAt
+}
+
+// endTrack closes the current track. Tracks of a single position are written as points.
+func (g *geoJSONWriter) endTrack() {
+	switch g.count {
+	case 0:
+		return
+	case 1:
+		g.startFeature()
+		g.write(`{"type":"Feature","geometry":{"type":"Point","coordinates":`)
+		g.coordinates(g.first.Latitude, g.first.Longitude, g.first.Altitude)
+		g.write(`}`)
+	default:
+		g.write(`]}`)
+	}
+
+	g.write(`,"properties":`)
+	g.writeJSON(trackProperties{
+		Kind:      "track",
+		Device:    g.first.Device,
+		From:      g.first.RecordedAt,
+		To:        g.to,
+		Positions: g.count,
+	})
+	g.write(`}`)
+
+	g.count = 0
+}
+
+func (g *geoJSONWriter) point(p model.Position) {
+	g.startFeature()
+	g.write(`{"type":"Feature","geometry":{"type":"Point","coordinates":`)
+	g.coordinates(p.Latitude, p.Longitude, p.Altitude)
+	g.write(`},"properties":`)
+	g.writeJSON(pointProperties{
+		Kind:       "position",
+		ID:         p.ID,
+		Device:     p.Device,
+		RecordedAt: p.RecordedAt,
+		Altitude:   p.Altitude,
+		Speed:      p.Speed,
+		Course:     p.Course,
+		Attributes: p.Meta,
+	})
+	g.write(`}`)
+}
+
+func (g *geoJSONWriter) end() {
+	g.endTrack()
+	g.write(`]}`)
+
+	if g.err == nil {
+		g.err = g.w.Flush()
+	}
+}
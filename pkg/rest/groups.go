@@ -0,0 +1,107 @@
+package rest
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/tsaron/anansi"
+	"tsaron.com/traccar-proxy/pkg/model"
+	"tsaron.com/traccar-proxy/pkg/traccar"
+)
+
+func Groups(r *chi.Mux, sessions *anansi.SessionStore, repo *traccar.Repo, onlineTimeout time.Duration) {
+	r.Route("/groups", func(r chi.Router) {
+		r.Use(sessions.Headless())
+
+		r.Get("/", getGroups(repo))
+		r.Get("/{id}", getGroup(repo))
+		r.Get("/{id}/devices", getGroupDevices(repo, onlineTimeout))
+	})
+}
+
+func getGroups(repo *traccar.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		groups, err := repo.Groups(r.Context())
+		if err != nil {
+			panic(errors.Wrap(err, "could not get groups"))
+		}
+
+		res := []model.Group{}
+		for i := range groups {
+			res = append(res, transformGroup(&groups[i]))
+		}
+
+		anansi.SendSuccess(r, w, res)
+	}
+}
+
+func getGroup(repo *traccar.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		anansi.SendSuccess(r, w, transformGroup(findGroup(r, repo)))
+	}
+}
+
+// getGroupDevices lists the devices in a group and all its subgroups
+func getGroupDevices(repo *traccar.Repo, onlineTimeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		group := findGroup(r, repo)
+
+		q := new(deviceQuery)
+		anansi.ReadQuery(r, q)
+		q.Group = group.ID
+
+		anansi.SendSuccess(r, w, listDevices(r, repo, q, onlineTimeout))
+	}
+}
+
+// findGroup loads the group in the URL, failing the request if it doesn't exist
+func findGroup(r *http.Request, repo *traccar.Repo) *traccar.Group {
+	group, err := repo.FindGroup(r.Context(), anansi.IDParam(r, "id"))
+	if err != nil {
+		panic(errors.Wrap(err, "could not get group"))
+	}
+
+	if group == nil {
+		panic(anansi.APIError{
+			Code:    http.StatusNotFound,
+			Message: "Could not find group with the given ID",
+		})
+	}
+
+	return group
+}
+
+func transformGroup(g *traccar.Group) model.Group {
+	group, err := traccar.TransformGroup(g)
+	if err != nil {
+		panic(anansi.APIError{
+			Code:    http.StatusUnprocessableEntity,
+			Message: "Could not parse group because of attribute",
+			Err:     err,
+			Meta:    group, // send this as it's still useful
+		})
+	}
+
+	return group
+}
+
+// withGroup adds the devices in group and its subgroups to devices. It returns false
+// when the group has no devices so callers don't mistake the result for no filter.
+func withGroup(r *http.Request, repo *traccar.Repo, devices []uint, group uint) ([]uint, bool) {
+	if group == 0 {
+		return devices, true
+	}
+
+	ids, err := repo.DeviceIDs(r.Context(), traccar.DeviceFilter{Group: group})
+	if err != nil {
+		panic(errors.Wrap(err, "could not get devices in group"))
+	}
+
+	if len(ids) == 0 {
+		return devices, len(devices) > 0
+	}
+
+	return append(devices, ids...), true
+}
@@ -0,0 +1,156 @@
+package rest
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog"
+	"github.com/tsaron/anansi"
+	"tsaron.com/traccar-proxy/pkg/model"
+	"tsaron.com/traccar-proxy/pkg/proxy"
+)
+
+const (
+	// how long we wait on a frame to be written to the client
+	liveWriteTimeout = 10 * time.Second
+	// how long the client has to respond to a ping
+	livePongTimeout = time.Minute
+	// how often we ping the client. It has to be less than the pong timeout
+	livePingInterval = 30 * time.Second
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// clients authenticate with headless tokens rather than cookies, so
+	// cross-origin connections can't ride on a user's session
+	CheckOrigin: func(_ *http.Request) bool { return true },
+}
+
+// liveRequest is sent by clients to change what they are subscribed to
+type liveRequest struct {
+	Action  string `json:"action"`
+	Devices []uint `json:"devices"`
+	Groups  []uint `json:"groups"`
+}
+
+// liveFrame is every message sent to clients
+type liveFrame struct {
+	Type    string      `json:"type"`
+	Data    interface{} `json:"data,omitempty"`
+	Message string      `json:"message,omitempty"`
+}
+
+func Live(r *chi.Mux, sessions *anansi.SessionStore, hub *proxy.Hub) {
+	r.With(sessions.Headless()).Get("/live", liveTracking(hub))
+}
+
+func liveTracking(hub *proxy.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := zerolog.Ctx(r.Context())
+
+		// the upgrader responds to the client on failure
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Err(err).Msg("could not upgrade to websocket")
+			return
+		}
+		defer conn.Close()
+
+		sub := hub.Subscribe()
+		defer sub.Close()
+
+		done := make(chan struct{})
+		defer close(done)
+
+		requests := make(chan liveRequest)
+		go readLiveRequests(conn, requests, done, log)
+
+		ping := time.NewTicker(livePingInterval)
+		defer ping.Stop()
+
+		for {
+			var frame liveFrame
+
+			select {
+			case req, ok := <-requests:
+				if !ok {
+					return
+				}
+
+				switch req.Action {
+				case "subscribe":
+					sub.Add(req.Devices...)
+					sub.AddGroups(req.Groups...)
+					frame = liveFrame{Type: "ack", Data: req}
+				case "unsubscribe":
+					sub.Remove(req.Devices...)
+					sub.RemoveGroups(req.Groups...)
+					frame = liveFrame{Type: "ack", Data: req}
+				default:
+					frame = liveFrame{Type: "error", Message: "action must be either subscribe or unsubscribe"}
+				}
+
+			case msg, ok := <-sub.C:
+				if !ok {
+					_ = conn.WriteControl(
+						websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is shutting down"),
+						time.Now().Add(liveWriteTimeout),
+					)
+					return
+				}
+
+				switch data := msg.Data.(type) {
+				case model.Position:
+					frame = liveFrame{Type: "position", Data: data}
+				case model.Event:
+					frame = liveFrame{Type: "event", Data: data}
+				default:
+					continue
+				}
+
+			case <-ping.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(liveWriteTimeout)); err != nil {
+					return
+				}
+				continue
+			}
+
+			_ = conn.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+			if err := conn.WriteJSON(frame); err != nil {
+				log.Err(err).Msg("could not write to websocket")
+				return
+			}
+		}
+	}
+}
+
+// readLiveRequests forwards client requests till the connection breaks, at which
+// point it closes requests.
+func readLiveRequests(conn *websocket.Conn, requests chan<- liveRequest, done <-chan struct{}, log *zerolog.Logger) {
+	defer close(requests)
+
+	_ = conn.SetReadDeadline(time.Now().Add(livePongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(livePongTimeout))
+	})
+
+	for {
+		var req liveRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Err(err).Msg("websocket closed unexpectedly")
+			}
+			return
+		}
+
+		select {
+		case requests <- req:
+		case <-done:
+			return
+		}
+	}
+}
@@ -1,33 +1,66 @@
 package rest
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/pkg/errors"
 	"github.com/tsaron/anansi"
 	"tsaron.com/traccar-proxy/pkg/model"
+	"tsaron.com/traccar-proxy/pkg/proxy"
 	"tsaron.com/traccar-proxy/pkg/traccar"
 )
 
+// page size for cursor pagination when there's no limit
+const defaultPositionPage = 500
+
 type latestPositionQuery struct {
 	Device uint `key:"device"`
 }
 
+type batchLatestQuery struct {
+	Group uint `key:"group"`
+}
+
 type positionQuery struct {
-	Device uint      `key:"device"`
 	Limit  int       `key:"limit"`
 	Offset int       `key:"offset"`
 	From   time.Time `key:"from"`
 	To     time.Time `key:"to"`
 	Order  string    `key:"order" default:"latest"`
+	// include the devices of a group and its subgroups
+	Group uint `key:"group"`
+	// bounding box as west,south,east,north like map libraries report viewports
+	BBox string `key:"bbox"`
+	// center of a radius search as latitude,longitude
+	Near string `key:"near"`
+	// radius in metres around near
+	Radius float64 `key:"radius"`
+	// polygon as latitude,longitude pairs separated by semicolons
+	Polygon string `key:"polygon"`
+	// add every position as a point to geojson tracks
+	Points bool `key:"points"`
+	// simplify tracks by dropping points within tolerance metres of the simplified track,
+	// keeping one point every sample_every seconds or at most max_points per device
+	Tolerance   float64 `key:"tolerance"`
+	SampleEvery int     `key:"sample_every"`
+	MaxPoints   int     `key:"max_points"`
+	// where the previous page ended. Passing it, even empty for the first page, switches
+	// the response to a page with the cursor of the next one.
+	Cursor string `key:"cursor"`
 }
 
-func Positions(r *chi.Mux, sessions *anansi.SessionStore, repo *traccar.Repo) {
+func Positions(r *chi.Mux, sessions *anansi.SessionStore, repo *traccar.Repo, hub *proxy.Hub, cache *proxy.PositionCache) {
 	r.Route("/positions", func(r chi.Router) {
 		r.With(sessions.Headless()).Get("/", getPositions(repo))
-		r.With(sessions.Headless()).Get("/latest", getLatestPosition(repo))
+		r.With(sessions.Headless()).Get("/latest", getLatestPosition(repo, cache))
+		r.With(sessions.Headless()).Get("/latest/batch", getLatestPositions(repo, cache))
+		r.With(sessions.Headless()).Get("/stream", streamPositions(repo, hub))
+		r.With(sessions.Headless()).Get("/export", exportPositions(repo))
 	})
 }
 
@@ -36,47 +69,121 @@ func getPositions(repo *traccar.Repo) http.HandlerFunc {
 		q := new(positionQuery)
 		anansi.ReadQuery(r, q)
 
-		if q.Device == 0 {
+		_, paged := r.URL.Query()["cursor"]
+
+		devices, ok := withGroup(r, repo, readDevices(r, "device"), q.Group)
+		if !ok && paged {
+			anansi.SendSuccess(r, w, page{Items: []model.Position{}})
+			return
+		} else if !ok {
+			anansi.SendSuccess(r, w, []model.Position{})
+			return
+		}
+
+		opts := traccar.QueryOpts{
+			Devices: devices,
+			From:    q.From,
+			To:      q.To,
+			Offset:  q.Offset,
+			Limit:   q.Limit,
+			Order:   q.Order,
+		}
+		readSpatialQuery(q, &opts)
+
+		// searching every device only makes sense within an area
+		if len(opts.Devices) == 0 && opts.BBox == nil && opts.Center == nil && opts.Polygon == nil {
 			panic(anansi.APIError{
 				Code:    http.StatusBadRequest,
-				Message: "You need to pass a device ID",
+				Message: "You need to pass a device ID, a group ID or an area to search",
 			})
 		}
 
-		if !q.From.IsZero() && q.To.IsZero() {
-			q.To = time.Now()
+		// without a time range an area search would scan the positions of every device
+		if len(opts.Devices) == 0 && opts.From.IsZero() {
+			panic(anansi.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "You need to pass from when searching an area without devices",
+			})
 		}
 
-		tps, err := repo.FindPositions(r.Context(), q.Device, traccar.QueryOpts{
-			From:   q.From,
-			To:     q.To,
-			Offset: q.Offset,
-			Limit:  q.Limit,
-			Order:  q.Order,
-		})
-		if err != nil {
-			panic(errors.Wrap(err, "could not get positions"))
+		if !opts.From.IsZero() && opts.To.IsZero() {
+			opts.To = time.Now()
 		}
 
-		var ps []model.Position
-		for _, tp := range tps {
-			p, err := traccar.TransformPosition(repo.RemoveTZ(&tp))
-			if err != nil {
+		simplify := readSimplifyOpts(q.Tolerance, q.SampleEvery, q.MaxPoints)
+
+		format := exportFormat(r)
+		if raw := r.URL.Query().Get("format"); raw != "" && raw != "json" && format == "" {
+			panic(anansi.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "format must be one of json, geojson, csv or ndjson",
+			})
+		}
+
+		if format != "" {
+			// downloads stream every position in one go
+			if paged {
+				panic(anansi.APIError{
+					Code:    http.StatusBadRequest,
+					Message: "You can't use a cursor with geojson, csv or ndjson",
+				})
+			}
+
+			if simplify.Enabled() && format != "geojson" {
+				panic(anansi.APIError{
+					Code:    http.StatusBadRequest,
+					Message: "tolerance, sample_every and max_points only work with JSON and GeoJSON",
+				})
+			}
+
+			// exports read better oldest first
+			if _, ok := r.URL.Query()["order"]; !ok {
+				opts.Order = "oldest"
+			}
+
+			downloadPositions(w, r, repo, opts, format, q.Points, simplify)
+			return
+		}
+
+		if paged {
+			if opts.Offset != 0 {
 				panic(anansi.APIError{
-					Code:    http.StatusUnprocessableEntity,
-					Message: "Could not parse position because of attribute",
-					Err:     err,
-					Meta:    p, // send this as it's still useful
+					Code:    http.StatusBadRequest,
+					Message: "You can't use offset with a cursor",
 				})
 			}
-			ps = append(ps, p)
+
+			if opts.Limit == 0 {
+				opts.Limit = defaultPositionPage
+			}
+
+			if q.Cursor != "" {
+				opts.After = new(traccar.PositionCursor)
+				decodeCursor(q.Cursor, opts.After)
+			}
 		}
 
-		anansi.SendSuccess(r, w, ps)
+		tps, err := repo.FindPositions(r.Context(), opts)
+		if err != nil {
+			panic(errors.Wrap(err, "could not get positions"))
+		}
+
+		ps := traccar.Simplify(transformPositions(repo, tps), simplify)
+		if !paged {
+			anansi.SendSuccess(r, w, ps)
+			return
+		}
+
+		res := page{Items: ps}
+		if len(tps) == opts.Limit {
+			res.NextCursor = encodeCursor(traccar.CursorOf(&tps[len(tps)-1]))
+		}
+
+		anansi.SendSuccess(r, w, res)
 	}
 }
 
-func getLatestPosition(repo *traccar.Repo) http.HandlerFunc {
+func getLatestPosition(repo *traccar.Repo, cache *proxy.PositionCache) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		q := new(latestPositionQuery)
 		anansi.ReadQuery(r, q)
@@ -88,6 +195,11 @@ func getLatestPosition(repo *traccar.Repo) http.HandlerFunc {
 			})
 		}
 
+		if pos, ok := cache.Get(q.Device); ok {
+			anansi.SendSuccess(r, w, pos)
+			return
+		}
+
 		p, err := repo.LatestPosition(r.Context(), q.Device)
 		// let anansi take care of the error
 		if err != nil {
@@ -99,16 +211,155 @@ func getLatestPosition(repo *traccar.Repo) http.HandlerFunc {
 			return
 		}
 
-		pos, err := traccar.TransformPosition(repo.RemoveTZ(p))
+		pos := traccar.TransformPosition(repo.RemoveTZ(p))
+		cache.Update(pos)
+
+		anansi.SendSuccess(r, w, pos)
+	}
+}
+
+func getLatestPositions(repo *traccar.Repo, cache *proxy.PositionCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := new(batchLatestQuery)
+		anansi.ReadQuery(r, q)
+
+		filter := traccar.DeviceFilter{
+			Devices:     readDevices(r, "device"),
+			ExternalIDs: readList(r, "external_id"),
+			Group:       q.Group,
+		}
+
+		if len(filter.Devices) == 0 && len(filter.ExternalIDs) == 0 && filter.Group == 0 {
+			panic(anansi.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "You need to pass device IDs, external IDs or a group ID",
+			})
+		}
+
+		// the cache is keyed by device ID
+		devices := filter.Devices
+		if len(filter.ExternalIDs) > 0 || filter.Group != 0 {
+			var err error
+			if devices, err = repo.DeviceIDs(r.Context(), filter); err != nil {
+				panic(errors.Wrap(err, "could not find devices"))
+			}
+		}
+
+		ps := []model.Position{}
+		var misses []uint
+		for _, d := range devices {
+			if p, ok := cache.Get(d); ok {
+				ps = append(ps, p)
+			} else {
+				misses = append(misses, d)
+			}
+		}
+
+		if len(misses) > 0 {
+			tps, err := repo.LatestPositions(r.Context(), traccar.DeviceFilter{Devices: misses})
+			if err != nil {
+				panic(errors.Wrap(err, "could not get latest positions"))
+			}
+
+			for _, p := range transformPositions(repo, tps) {
+				cache.Update(p)
+				ps = append(ps, p)
+			}
+		}
+
+		anansi.SendSuccess(r, w, ps)
+	}
+}
+
+// transformPositions converts positions from traccar's format
+func transformPositions(repo *traccar.Repo, tps []traccar.Position) []model.Position {
+	ps := []model.Position{}
+	for _, tp := range tps {
+		ps = append(ps, traccar.TransformPosition(repo.RemoveTZ(&tp)))
+	}
+
+	return ps
+}
+
+// readSimplifyOpts builds the track simplification options from the query
+func readSimplifyOpts(tolerance float64, sampleEvery, maxPoints int) traccar.SimplifyOpts {
+	if tolerance < 0 || sampleEvery < 0 || maxPoints < 0 {
+		panic(anansi.APIError{
+			Code:    http.StatusBadRequest,
+			Message: "tolerance, sample_every and max_points can't be negative",
+		})
+	}
+
+	return traccar.SimplifyOpts{
+		Tolerance: tolerance,
+		Interval:  time.Duration(sampleEvery) * time.Second,
+		MaxPoints: maxPoints,
+	}
+}
+
+// readSpatialQuery sets the area filters of opts from the query
+func readSpatialQuery(q *positionQuery, opts *traccar.QueryOpts) {
+	if q.BBox != "" {
+		c := parseCoordinates(q.BBox, "bbox")
+		if len(c) != 4 || c[0] > c[2] || c[1] > c[3] {
+			panic(anansi.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "bbox must be west,south,east,north",
+			})
+		}
+
+		opts.BBox = &traccar.BBox{MinLongitude: c[0], MinLatitude: c[1], MaxLongitude: c[2], MaxLatitude: c[3]}
+	}
+
+	if q.Near != "" || q.Radius != 0 {
+		c := parseCoordinates(q.Near, "near")
+		if len(c) != 2 || q.Radius <= 0 {
+			panic(anansi.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "You need to pass near as latitude,longitude with a positive radius",
+			})
+		}
+
+		opts.Center = &model.Point{Latitude: c[0], Longitude: c[1]}
+		opts.Radius = q.Radius
+	}
+
+	if q.Polygon != "" {
+		for _, raw := range strings.Split(q.Polygon, ";") {
+			c := parseCoordinates(raw, "polygon")
+			if len(c) != 2 {
+				panic(anansi.APIError{
+					Code:    http.StatusBadRequest,
+					Message: "polygon must be latitude,longitude pairs separated by semicolons",
+				})
+			}
+
+			opts.Polygon = append(opts.Polygon, model.Point{Latitude: c[0], Longitude: c[1]})
+		}
+
+		if len(opts.Polygon) < 3 {
+			panic(anansi.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "polygon needs at least 3 points",
+			})
+		}
+	}
+}
+
+func parseCoordinates(raw, key string) []float64 {
+	var coords []float64
+
+	for _, part := range strings.Split(raw, ",") {
+		c, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
 		if err != nil {
 			panic(anansi.APIError{
-				Code:    http.StatusUnprocessableEntity,
-				Message: "Could not parse position because of attribute",
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("%s must be a list of coordinates", key),
 				Err:     err,
-				Meta:    pos, // send this as it's still useful
 			})
 		}
-
-		anansi.SendSuccess(r, w, pos)
+		coords = append(coords, c)
 	}
+
+	return coords
 }
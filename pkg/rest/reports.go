@@ -0,0 +1,158 @@
+package rest
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/tsaron/anansi"
+	"tsaron.com/traccar-proxy/pkg/model"
+	"tsaron.com/traccar-proxy/pkg/traccar"
+)
+
+type tripQuery struct {
+	Device uint      `key:"device"`
+	From   time.Time `key:"from"`
+	To     time.Time `key:"to"`
+	// how long in seconds a device has to be still for a trip to end
+	StopDuration int `key:"stop_duration" default:"300"`
+	// speed in knots above which a device is moving
+	MinSpeed float64 `key:"min_speed" default:"2"`
+	Ignition bool    `key:"ignition"`
+}
+
+type stopQuery struct {
+	Device uint      `key:"device"`
+	From   time.Time `key:"from"`
+	To     time.Time `key:"to"`
+	// how long in seconds a device has to be around the same place to be stopped
+	MinDuration int `key:"min_duration" default:"300"`
+	// how far in metres a stopped device can drift
+	Radius float64 `key:"radius" default:"50"`
+}
+
+type summaryQuery struct {
+	Group    uint      `key:"group"`
+	From     time.Time `key:"from"`
+	To       time.Time `key:"to"`
+	Interval string    `key:"interval" default:"day"`
+}
+
+func Reports(r *chi.Mux, sessions *anansi.SessionStore, repo *traccar.Repo) {
+	r.With(sessions.Headless()).Get("/trips", getTrips(repo))
+	r.With(sessions.Headless()).Get("/stops", getStops(repo))
+	r.With(sessions.Headless()).Get("/reports/summary", getSummary(repo))
+}
+
+func getTrips(repo *traccar.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := new(tripQuery)
+		anansi.ReadQuery(r, q)
+
+		ps := reportPositions(r, repo, q.Device, q.From, q.To)
+
+		trips := traccar.DetectTrips(ps, traccar.TripOpts{
+			MinStopDuration: time.Duration(q.StopDuration) * time.Second,
+			SpeedThreshold:  q.MinSpeed,
+			UseIgnition:     q.Ignition,
+		})
+
+		anansi.SendSuccess(r, w, trips)
+	}
+}
+
+func getStops(repo *traccar.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := new(stopQuery)
+		anansi.ReadQuery(r, q)
+
+		ps := reportPositions(r, repo, q.Device, q.From, q.To)
+
+		stops := traccar.DetectStops(ps, traccar.StopOpts{
+			MinDuration: time.Duration(q.MinDuration) * time.Second,
+			Radius:      q.Radius,
+		})
+
+		anansi.SendSuccess(r, w, stops)
+	}
+}
+
+func getSummary(repo *traccar.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := new(summaryQuery)
+		anansi.ReadQuery(r, q)
+
+		devices, ok := withGroup(r, repo, readDevices(r, "device"), q.Group)
+		if len(devices) == 0 && q.Group == 0 {
+			panic(anansi.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "You need to pass at least one device ID or a group ID",
+			})
+		}
+
+		if !ok {
+			anansi.SendSuccess(r, w, []model.Summary{})
+			return
+		}
+
+		if q.From.IsZero() {
+			panic(anansi.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "You need to pass the start of the report period",
+			})
+		}
+
+		if q.To.IsZero() {
+			q.To = time.Now()
+		}
+
+		if !traccar.SummaryIntervals[q.Interval] {
+			panic(anansi.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "interval must be one of day, week or month",
+			})
+		}
+
+		summaries, err := repo.Summarise(r.Context(), devices, q.From, q.To, q.Interval)
+		if err != nil {
+			panic(errors.Wrap(err, "could not summarise positions"))
+		}
+
+		anansi.SendSuccess(r, w, summaries)
+	}
+}
+
+// reportPositions loads the positions of a device within a report period, oldest first.
+// The period must have a start and ends now if to is not set.
+func reportPositions(r *http.Request, repo *traccar.Repo, device uint, from, to time.Time) []model.Position {
+	if device == 0 {
+		panic(anansi.APIError{
+			Code:    http.StatusBadRequest,
+			Message: "You need to pass a device ID",
+		})
+	}
+
+	if from.IsZero() {
+		panic(anansi.APIError{
+			Code:    http.StatusBadRequest,
+			Message: "You need to pass the start of the report period",
+		})
+	}
+
+	if to.IsZero() {
+		to = time.Now()
+	}
+
+	tps, err := repo.FindPositions(r.Context(), traccar.QueryOpts{
+		Devices: []uint{device},
+		From:    from,
+		To:      to,
+		Order:   "oldest",
+	})
+	if err != nil {
+		panic(errors.Wrap(err, "could not get positions"))
+	}
+
+	return transformPositions(repo, tps)
+}
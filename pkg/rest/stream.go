@@ -0,0 +1,271 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/tsaron/anansi"
+	"tsaron.com/traccar-proxy/pkg/model"
+	"tsaron.com/traccar-proxy/pkg/proxy"
+	"tsaron.com/traccar-proxy/pkg/traccar"
+)
+
+const (
+	// how often idle streams get a comment to keep proxies from closing them
+	streamHeartbeat = 15 * time.Second
+	// the most positions we replay when a client resumes a stream. Clients that
+	// missed more get a reset event and should reload their history.
+	streamBacklog = 1000
+)
+
+// Timeout works like chi's timeout middleware but leaves long-lived streaming
+// requests (server-sent events, websockets and downloads) alone.
+func Timeout(timeout time.Duration) func(http.Handler) http.Handler {
+	withTimeout := middleware.Timeout(timeout)
+
+	return func(next http.Handler) http.Handler {
+		timed := withTimeout(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isStreaming(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			timed.ServeHTTP(w, r)
+		})
+	}
+}
+
+// tokenRoutes are the routes that take the session token as a query parameter,
+// as browsers can't set headers on EventSource and WebSocket connections
+var tokenRoutes = map[string]bool{
+	"/positions/stream": true,
+	"/live":             true,
+}
+
+// QueryToken moves the access_token query parameter into the Authorization header
+// on tokenRoutes, so the usual session middleware can read it. It should run before
+// requests are logged so the token stays out of the URL.
+func QueryToken(scheme string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			token := query.Get("access_token")
+
+			if token != "" && r.Header.Get("Authorization") == "" && tokenRoutes[routePath(r)] {
+				r.Header.Set("Authorization", scheme+" "+token)
+				query.Del("access_token")
+				r.URL.RawQuery = query.Encode()
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// streamingRoutes are the routes that can outlive the timeout, by their path within
+// the router, along with the requests on them that stream. Headers and parameters
+// alone aren't trusted as any client could send them to hold a request open.
+var streamingRoutes = map[string]func(*http.Request) bool{
+	"/positions/stream": func(r *http.Request) bool { return true },
+	"/positions":        func(r *http.Request) bool { return exportFormat(r) != "" },
+	"/live": func(r *http.Request) bool {
+		return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+	},
+}
+
+func isStreaming(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+
+	streams, ok := streamingRoutes[routePath(r)]
+	return ok && streams(r)
+}
+
+// routePath is the path of r within the router, which differs from the URL's
+// when the router is mounted.
+func routePath(r *http.Request) string {
+	path := r.URL.Path
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePath != "" {
+		path = rctx.RoutePath
+	}
+
+	return strings.TrimSuffix(path, "/")
+}
+
+// exportFormat returns the format positions should be downloaded in, either from the
+// format query parameter or the Accept header. It's empty for normal JSON responses
+// and formats that can't be downloaded.
+func exportFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		if downloadTypes[format] == "" {
+			return ""
+		}
+		return format
+	}
+
+	accept := r.Header.Get("Accept")
+	for format, contentType := range downloadTypes {
+		if strings.Contains(accept, contentType) {
+			return format
+		}
+	}
+
+	return ""
+}
+
+// readList reads values passed as repeated or comma separated query parameters
+func readList(r *http.Request, key string) []string {
+	var values []string
+
+	for _, raw := range r.URL.Query()[key] {
+		for _, v := range strings.Split(raw, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+
+	return values
+}
+
+// readDevices reads device IDs passed as repeated or comma separated query parameters
+func readDevices(r *http.Request, key string) []uint {
+	var devices []uint
+
+	for _, id := range readList(r, key) {
+		d, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			panic(anansi.APIError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("%s must be a list of IDs", key),
+				Err:     err,
+			})
+		}
+
+		devices = append(devices, uint(d))
+	}
+
+	return devices
+}
+
+func streamPositions(repo *traccar.Repo, hub *proxy.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := zerolog.Ctx(r.Context())
+
+		devices := readDevices(r, "device")
+		if len(devices) == 0 {
+			panic(anansi.APIError{
+				Code:    http.StatusBadRequest,
+				Message: "You need to pass at least one device ID",
+			})
+		}
+
+		var lastID uint64
+		if raw := r.Header.Get("Last-Event-ID"); raw != "" {
+			var err error
+			if lastID, err = strconv.ParseUint(raw, 10, 32); err != nil {
+				panic(anansi.APIError{
+					Code:    http.StatusBadRequest,
+					Message: "Last-Event-ID must be a position ID",
+					Err:     err,
+				})
+			}
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			panic(errors.New("response writer does not support streaming"))
+		}
+
+		// subscribe before loading missed positions so nothing falls in between
+		sub := hub.Subscribe(devices...)
+		defer sub.Close()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		// positions replayed from the database that will also come in live
+		replayed := make(map[uint]bool)
+
+		if lastID != 0 {
+			// one more than we replay tells us whether the client missed too much
+			tps, err := repo.PositionsAfter(r.Context(), uint(lastID), streamBacklog+1, devices...)
+			if err != nil {
+				log.Err(err).Msg("could not load missed positions")
+				return
+			}
+
+			// rather than leave a gap, tell the client to reload history itself
+			if len(tps) > streamBacklog {
+				if _, err := fmt.Fprint(w, "event: reset\ndata: {}\n\n"); err != nil {
+					return
+				}
+				tps = nil
+			}
+
+			for _, tp := range tps {
+				p := traccar.TransformPosition(repo.RemoveTZ(&tp))
+				if err := writeEvent(w, p); err != nil {
+					return
+				}
+				replayed[p.ID] = true
+			}
+			flusher.Flush()
+		}
+
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+			case msg, ok := <-sub.C:
+				if !ok {
+					return
+				}
+
+				p, ok := msg.Data.(model.Position)
+				if !ok || replayed[p.ID] {
+					continue
+				}
+
+				if err := writeEvent(w, p); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// writeEvent writes p as a server-sent event with the position ID as the event ID
+func writeEvent(w http.ResponseWriter, p model.Position) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: position\ndata: %s\n\n", p.ID, data)
+	return err
+}
@@ -0,0 +1,119 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+func TestTimeout(t *testing.T) {
+	var called, timed bool
+	record := func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		_, timed = r.Context().Deadline()
+	}
+
+	api := chi.NewRouter()
+	api.Use(Timeout(time.Minute))
+	api.Route("/positions", func(r chi.Router) {
+		r.Get("/", record)
+		r.Get("/stream", record)
+		r.Get("/export", record)
+	})
+	api.Get("/live", record)
+	api.Get("/devices", record)
+
+	// the API is mounted like it is in main
+	router := chi.NewRouter()
+	router.Mount("/api", api)
+
+	tests := []struct {
+		name    string
+		target  string
+		headers map[string]string
+		want    bool
+	}{
+		{"positions", "/api/positions?device=1", nil, true},
+		{"positions download", "/api/positions/?device=1&format=csv", nil, false},
+		{"positions download by accept", "/api/positions", map[string]string{"Accept": geoJSONType}, false},
+		{"positions explicit json", "/api/positions?format=json", map[string]string{"Accept": csvType}, true},
+		{"positions unknown format", "/api/positions?format=gpx", nil, true},
+		{"stream", "/api/positions/stream", nil, false},
+		// exports are built in memory so they don't get to outlive the timeout
+		{"export", "/api/positions/export?format=kml", nil, true},
+		{"live", "/api/live", map[string]string{"Upgrade": "websocket"}, false},
+		{"live without upgrade", "/api/live", nil, true},
+		{"other route with download format", "/api/devices?format=ndjson", nil, true},
+		{"other route with event stream", "/api/devices", map[string]string{"Accept": "text/event-stream"}, true},
+		{"other route with upgrade", "/api/devices", map[string]string{"Upgrade": "websocket"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			called, timed = false, false
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			if !called {
+				t.Fatalf("%s didn't reach its handler", tt.target)
+			}
+
+			if timed != tt.want {
+				t.Errorf("timed out = %v, want %v", timed, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryToken(t *testing.T) {
+	var auth, query string
+	record := func(w http.ResponseWriter, r *http.Request) {
+		auth, query = r.Header.Get("Authorization"), r.URL.RawQuery
+	}
+
+	api := chi.NewRouter()
+	api.Use(QueryToken("Bearer"))
+	api.Get("/positions/stream", record)
+	api.Get("/live", record)
+	api.Get("/devices", record)
+
+	router := chi.NewRouter()
+	router.Mount("/api", api)
+
+	tests := []struct {
+		name      string
+		target    string
+		header    string
+		wantAuth  string
+		wantQuery string
+	}{
+		{"stream", "/api/positions/stream?device=1&access_token=abc", "", "Bearer abc", "device=1"},
+		{"live", "/api/live?access_token=abc", "", "Bearer abc", ""},
+		{"header wins", "/api/live?access_token=abc", "Bearer xyz", "Bearer xyz", "access_token=abc"},
+		{"other route", "/api/devices?access_token=abc", "", "", "access_token=abc"},
+		{"no token", "/api/positions/stream?device=1", "", "", "device=1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+
+			auth, query = "", ""
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			if auth != tt.wantAuth || query != tt.wantQuery {
+				t.Errorf("got Authorization %q and query %q, want %q and %q", auth, query, tt.wantAuth, tt.wantQuery)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package traccar
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"tsaron.com/traccar-proxy/pkg/model"
+)
+
+// mappedAttributes are the traccar attributes with a field in model.Attributes
+var mappedAttributes = map[string]bool{
+	"fuelConsumption":     true,
+	"raw":                 true,
+	"gSensor":             true,
+	"motion":              true,
+	"totalDistance":       true,
+	"rpm":                 true,
+	"alarm":               true,
+	"ignition":            true,
+	"dtcs":                true,
+	"engineLoad":          true,
+	"coolantTemp":         true,
+	"tripOdometer":        true,
+	"intakeTemp":          true,
+	"odometer":            true,
+	"mapIntake":           true,
+	"throttle":            true,
+	"milDistance":         true,
+	"sat":                 true,
+	"tripFuelConsumption": true,
+}
+
+// attributeNames renames the attributes passed through as extras. It's only
+// set once at startup.
+var attributeNames = map[string]string{}
+
+// LoadAttributeNames reads the JSON object in the file at path mapping traccar attribute
+// names to the names they should have in the extra attributes of positions.
+func LoadAttributeNames(path string) error {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]string)
+	if err := json.Unmarshal(raw, &names); err != nil {
+		return errors.Wrap(err, "could not decode attribute names")
+	}
+
+	for from, to := range names {
+		if to == "" {
+			return errors.Errorf("attribute %s has no new name", from)
+		}
+	}
+
+	attributeNames = names
+	return nil
+}
+
+// extraAttributes picks the attributes in raw that don't have a field of their
+// own, renaming them according to attributeNames. Values are kept as traccar sent
+// them so large numbers don't lose precision. It returns nil if there are none.
+func extraAttributes(raw map[string]json.RawMessage) map[string]json.RawMessage {
+	var extra map[string]json.RawMessage
+
+	for k, v := range raw {
+		if mappedAttributes[k] {
+			continue
+		}
+
+		if extra == nil {
+			extra = make(map[string]json.RawMessage)
+		}
+
+		if name, ok := attributeNames[k]; ok {
+			k = name
+		}
+
+		extra[k] = v
+	}
+
+	return extra
+}
+
+// decodeAttributes reads the attributes traccar stores as JSON into attr, converting values
+// of the wrong type where it makes sense (e.g. numbers sent as strings). Attributes that
+// can't be converted are left empty with a warning. It also returns every attribute undecoded.
+func decodeAttributes(payload string, attr *model.TraccarAttributes) (map[string]json.RawMessage, []model.AttributeWarning) {
+	if payload == "" {
+		return nil, nil
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(payload), &raw); err != nil {
+		return nil, []model.AttributeWarning{{Attribute: "attributes", Message: err.Error()}}
+	}
+
+	var warnings []model.AttributeWarning
+
+	v := reflect.ValueOf(attr).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		// the rest are passed through as extras
+		name := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+		if !mappedAttributes[name] {
+			continue
+		}
+
+		value, ok := raw[name]
+		if !ok || string(value) == "null" {
+			continue
+		}
+
+		field := v.Field(i)
+		if err := json.Unmarshal(value, field.Addr().Interface()); err == nil {
+			continue
+		}
+
+		if err := coerceAttribute(value, field); err != nil {
+			field.Set(reflect.Zero(field.Type()))
+			warnings = append(warnings, model.AttributeWarning{
+				Attribute: name,
+				Value:     value,
+				Message:   err.Error(),
+			})
+		}
+	}
+
+	return raw, warnings
+}
+
+// coerceAttribute sets field from a JSON value of a different type
+func coerceAttribute(value json.RawMessage, field reflect.Value) error {
+	var x interface{}
+	if err := json.Unmarshal(value, &x); err != nil {
+		return err
+	}
+
+	switch field.Kind() {
+	case reflect.Bool:
+		switch t := x.(type) {
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(t))
+			if err != nil {
+				return fmt.Errorf("%q is not a boolean", t)
+			}
+			field.SetBool(b)
+		case float64:
+			field.SetBool(t != 0)
+		default:
+			return fmt.Errorf("%s is not a boolean", value)
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		f, err := attributeNumber(x, value)
+		if err != nil {
+			return err
+		}
+
+		i := int64(math.Round(f))
+		if field.OverflowInt(i) {
+			return fmt.Errorf("%s is out of range", value)
+		}
+		field.SetInt(i)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		f, err := attributeNumber(x, value)
+		if err != nil {
+			return err
+		}
+
+		if f < 0 {
+			return fmt.Errorf("%s can't be negative", value)
+		}
+
+		u := uint64(math.Round(f))
+		if field.OverflowUint(u) {
+			return fmt.Errorf("%s is out of range", value)
+		}
+		field.SetUint(u)
+
+	case reflect.Float32, reflect.Float64:
+		f, err := attributeNumber(x, value)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+
+	case reflect.String:
+		switch t := x.(type) {
+		case float64:
+			field.SetString(strconv.FormatFloat(t, 'f', -1, 64))
+		case bool:
+			field.SetString(strconv.FormatBool(t))
+		default:
+			return fmt.Errorf("%s is not a string", value)
+		}
+
+	default:
+		return fmt.Errorf("%s can't be converted", value)
+	}
+
+	return nil
+}
+
+// attributeNumber reads x as a number, parsing it if it's a string
+func attributeNumber(x interface{}, value json.RawMessage) (float64, error) {
+	switch t := x.(type) {
+	case float64:
+		return t, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return 0, fmt.Errorf("%q is not a number", t)
+		}
+		return f, nil
+	default:
+		return 0, fmt.Errorf("%s is not a number", value)
+	}
+}
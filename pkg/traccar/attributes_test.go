@@ -0,0 +1,146 @@
+package traccar
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"tsaron.com/traccar-proxy/pkg/model"
+)
+
+func TestDecodeAttributes(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  string
+		want     model.TraccarAttributes
+		warnings []string
+	}{
+		{"empty", "", model.TraccarAttributes{}, nil},
+		{"invalid JSON", "{", model.TraccarAttributes{}, []string{"attributes"}},
+		{
+			"well typed",
+			`{"ignition":true,"rpm":1200,"alarm":"sos","fuelConsumption":1.5}`,
+			model.TraccarAttributes{Ignition: true, RPM: 1200, Alarm: "sos", FuelConsumption: 1.5},
+			nil,
+		},
+		{
+			"coerced",
+			`{"ignition":"true","rpm":"1200","sat":7.4,"alarm":12,"motion":1}`,
+			model.TraccarAttributes{Ignition: true, RPM: 1200, Satellites: 7, Alarm: "12", Motion: true},
+			nil,
+		},
+		{"null", `{"rpm":null}`, model.TraccarAttributes{}, nil},
+		{
+			"unreadable values are left out",
+			`{"rpm":"fast","ignition":"maybe","odometer":-5,"throttle":25}`,
+			model.TraccarAttributes{Throttle: 25},
+			[]string{"rpm", "ignition", "odometer"},
+		},
+		{
+			// status isn't mapped so it's passed through as an extra instead
+			"unmapped",
+			`{"status":"broken","rpm":10}`,
+			model.TraccarAttributes{RPM: 10},
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attr model.TraccarAttributes
+			_, warnings := decodeAttributes(tt.payload, &attr)
+
+			if !reflect.DeepEqual(attr, tt.want) {
+				t.Errorf("decodeAttributes() attributes = %+v, want %+v", attr, tt.want)
+			}
+
+			var names []string
+			for _, w := range warnings {
+				names = append(names, w.Attribute)
+			}
+
+			if !reflect.DeepEqual(names, tt.warnings) {
+				t.Errorf("decodeAttributes() warnings = %v, want %v", names, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestCoerceAttribute(t *testing.T) {
+	var attr struct {
+		Bool   bool
+		Int    int
+		Int8   int8
+		Uint   uint
+		Float  float32
+		String string
+	}
+	fields := reflect.ValueOf(&attr).Elem()
+
+	tests := []struct {
+		name    string
+		field   string
+		value   string
+		want    interface{}
+		wantErr bool
+	}{
+		{"bool from string", "Bool", `" TRUE "`, true, false},
+		{"bool from number", "Bool", `0`, false, false},
+		{"bool from word", "Bool", `"yes"`, nil, true},
+		{"bool from object", "Bool", `{}`, nil, true},
+		{"int from string", "Int", `"-42"`, -42, false},
+		{"int rounds", "Int", `2.6`, 3, false},
+		{"int overflow", "Int8", `300`, nil, true},
+		{"uint from string", "Uint", `"7"`, uint(7), false},
+		{"negative uint", "Uint", `-1`, nil, true},
+		{"float from string", "Float", `"1.25"`, float32(1.25), false},
+		{"float from NaN", "Float", `"NaN"`, nil, true},
+		{"float from bool", "Float", `true`, nil, true},
+		{"string from number", "String", `1.5`, "1.5", false},
+		{"string from bool", "String", `false`, "false", false},
+		{"string from array", "String", `[1]`, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			field := fields.FieldByName(tt.field)
+			field.Set(reflect.Zero(field.Type()))
+
+			err := coerceAttribute(json.RawMessage(tt.value), field)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("coerceAttribute() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if got := field.Interface(); got != tt.want {
+				t.Errorf("coerceAttribute() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtraAttributes(t *testing.T) {
+	raw := map[string]json.RawMessage{
+		"rpm":    json.RawMessage(`1200`),
+		"iccid":  json.RawMessage(`89234000012345678901`),
+		"power":  json.RawMessage(`12.5`),
+		"events": json.RawMessage(`["a","b"]`),
+	}
+
+	want := map[string]json.RawMessage{
+		"iccid":  json.RawMessage(`89234000012345678901`),
+		"power":  json.RawMessage(`12.5`),
+		"events": json.RawMessage(`["a","b"]`),
+	}
+
+	if got := extraAttributes(raw); !reflect.DeepEqual(got, want) {
+		t.Errorf("extraAttributes() = %s, want %s", got, want)
+	}
+
+	if got := extraAttributes(map[string]json.RawMessage{"rpm": json.RawMessage(`1`)}); got != nil {
+		t.Errorf("extraAttributes() = %s, want nil", got)
+	}
+}
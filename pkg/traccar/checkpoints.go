@@ -0,0 +1,72 @@
+package traccar
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+)
+
+// Checkpoint records the last row of a table that has been handled by a consumer
+type Checkpoint struct {
+	tableName struct{}  `pg:"proxy_checkpoints"`
+	Name      string    `pg:",pk"`
+	Position  uint      `pg:"position_id,use_zero"`
+	UpdatedAt time.Time `pg:"updated_at"`
+}
+
+// LoadCheckpoint returns the last position ID saved under name. If there's no checkpoint
+// yet, it is created from the newest position so we don't replay all of history.
+func (r *Repo) LoadCheckpoint(ctx context.Context, name string) (uint, error) {
+	cp := &Checkpoint{Name: name}
+
+	err := r.db.ModelContext(ctx, cp).WherePK().Select()
+	if err == nil {
+		return cp.Position, nil
+	}
+
+	if err != pg.ErrNoRows {
+		return 0, err
+	}
+
+	_, err = r.db.QueryOneContext(ctx, pg.Scan(&cp.Position), "SELECT coalesce(max(id), 0) FROM tc_positions")
+	if err != nil {
+		return 0, err
+	}
+
+	return cp.Position, r.SaveCheckpoint(ctx, name, cp.Position)
+}
+
+// SaveCheckpoint moves the checkpoint for name to the given position ID
+func (r *Repo) SaveCheckpoint(ctx context.Context, name string, position uint) error {
+	cp := &Checkpoint{Name: name, Position: position, UpdatedAt: time.Now()}
+
+	_, err := r.db.
+		ModelContext(ctx, cp).
+		OnConflict("(name) DO UPDATE").
+		Set("position_id = EXCLUDED.position_id").
+		Set("updated_at = EXCLUDED.updated_at").
+		Insert()
+
+	return err
+}
+
+// PositionsAfter returns at most limit positions whose IDs come after the given ID,
+// in the order they were inserted. Passing devices limits the positions to those devices.
+func (r *Repo) PositionsAfter(ctx context.Context, id uint, limit int, devices ...uint) ([]Position, error) {
+	positions := []Position{}
+
+	query := r.db.
+		ModelContext(ctx, &positions).
+		Where("id > ?", id).
+		Order("id ASC").
+		Limit(limit)
+
+	if len(devices) > 0 {
+		query = query.Where("deviceid IN (?)", pg.In(devices))
+	}
+
+	err := query.Select()
+
+	return positions, err
+}
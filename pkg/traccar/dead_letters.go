@@ -0,0 +1,25 @@
+package traccar
+
+import (
+	"context"
+	"time"
+)
+
+// DeadLetter is a message that could not be delivered to a webhook
+type DeadLetter struct {
+	tableName struct{} `pg:"proxy_dead_letters"`
+	ID        uint
+	URL       string
+	Subject   string
+	MessageID string
+	Body      string
+	Error     string
+	Attempts  int       `pg:",use_zero"`
+	CreatedAt time.Time `pg:"default:now()"`
+}
+
+// SaveDeadLetter stores a failed delivery so it can be inspected and replayed later
+func (r *Repo) SaveDeadLetter(ctx context.Context, dl *DeadLetter) error {
+	_, err := r.db.ModelContext(ctx, dl).Insert()
+	return err
+}
@@ -0,0 +1,216 @@
+package traccar
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
+	"tsaron.com/traccar-proxy/pkg/model"
+)
+
+const pgTimestampf = "2006-01-02 15:04:05.999999"
+
+// columns devices can be sorted by and the type of their cursor values. lastupdate
+// is null for devices that never connected.
+var deviceSorts = map[string][2]string{
+	"id":          {"device.id", "bigint"},
+	"name":        {"device.name", "text"},
+	"last_update": {"coalesce(device.lastupdate, '0001-01-01'::timestamp)", "timestamp"},
+}
+
+// DeviceCursor marks the last device of a page by its sort value and ID
+type DeviceCursor struct {
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+type DeviceQuery struct {
+	// Only return devices whose name contains this, ignoring case
+	Name     string
+	Category string
+	Model    string
+	// Only return devices in this group or any of its subgroups
+	Group    uint
+	Disabled *bool
+	// Only return devices that have (or haven't) reported since OnlineSince
+	Online      *bool
+	OnlineSince time.Time
+	// Column to sort by, one of id, name or last_update
+	Sort string
+	// Order of the results, either "asc" or "desc"
+	Order string
+	// Only return devices after this one in the sort order
+	After *DeviceCursor
+	Limit int
+}
+
+// FindDevices returns a page of devices matching q along with the cursor
+// of its last device.
+func (r *Repo) FindDevices(ctx context.Context, q DeviceQuery) ([]Device, *DeviceCursor, error) {
+	devices := []Device{}
+
+	sort, ok := deviceSorts[q.Sort]
+	if !ok || (q.Order != "asc" && q.Order != "desc") || q.Limit <= 0 {
+		return nil, nil, ErrInvalidQuery
+	}
+
+	if q.Online != nil && q.OnlineSince.IsZero() {
+		return nil, nil, ErrInvalidQuery
+	}
+
+	query := r.db.
+		ModelContext(ctx, &devices).
+		Apply(q.filter).
+		OrderExpr(sort[0] + " " + q.Order).
+		Limit(q.Limit)
+
+	if q.Sort != "id" {
+		query = query.OrderExpr("device.id " + q.Order)
+	}
+
+	if q.After != nil {
+		cmp := ">"
+		if q.Order == "desc" {
+			cmp = "<"
+		}
+
+		if q.Sort == "id" {
+			query = query.Where("device.id "+cmp+" ?", q.After.ID)
+		} else {
+			// the ID comes second so devices with the same value don't get skipped
+			query = query.Where(
+				"(?, device.id) "+cmp+" (?::?, ?)",
+				pg.Safe(sort[0]), q.After.Value, pg.Safe(sort[1]), q.After.ID,
+			)
+		}
+	}
+
+	if err := query.Select(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(devices) < q.Limit {
+		return devices, nil, nil
+	}
+
+	last := devices[len(devices)-1]
+	cursor := &DeviceCursor{ID: last.ID}
+
+	switch q.Sort {
+	case "name":
+		cursor.Value = last.Name
+	case "last_update":
+		cursor.Value = last.UpdatedAt.Format(pgTimestampf)
+	}
+
+	return devices, cursor, nil
+}
+
+// filter adds the criteria of q to a query on devices
+func (q DeviceQuery) filter(query *orm.Query) (*orm.Query, error) {
+	if q.Name != "" {
+		query = query.Where("device.name ILIKE ?", "%"+escapeLike(q.Name)+"%")
+	}
+
+	if q.Category != "" {
+		query = query.Where("device.category = ?", q.Category)
+	}
+
+	if q.Model != "" {
+		query = query.Where("device.model = ?", q.Model)
+	}
+
+	if q.Group != 0 {
+		query = query.Where("device.groupid IN ("+groupTreeSQL+")", q.Group)
+	}
+
+	if q.Disabled != nil {
+		query = query.Where("device.disabled = ?", *q.Disabled)
+	}
+
+	if q.Online != nil {
+		since := q.OnlineSince.UTC().Format(pgTimestampf)
+
+		if *q.Online {
+			query = query.Where("device.lastupdate >= ?::timestamp", since)
+		} else {
+			query = query.Where("(device.lastupdate IS NULL OR device.lastupdate < ?::timestamp)", since)
+		}
+	}
+
+	return query, nil
+}
+
+// escapeLike stops LIKE from treating characters in s as wildcards
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func (r *Repo) RemoveDeviceTZ(d *Device) model.TraccarDevice {
+	return model.TraccarDevice{
+		ID:         d.ID,
+		UpdatedAt:  model.ISOWithoutTZ(d.UpdatedAt),
+		Name:       d.Name,
+		ExternalID: d.ExternalID,
+		Position:   d.LastPosition,
+		Payload:    d.Payload,
+		Phone:      d.Phone,
+		Model:      d.Model,
+		Contact:    d.Contact,
+		Category:   d.Category,
+		Disabled:   d.Disabled,
+		Group:      d.Group,
+	}
+}
+
+// CreateDevice adds a device to traccar. It returns ErrDuplicateDevice if another device has
+// the same external ID and ErrUnknownGroup if its group doesn't exist.
+func (r *Repo) CreateDevice(ctx context.Context, device *Device) error {
+	_, err := r.db.ModelContext(ctx, device).Returning("*").Insert()
+	return deviceError(err)
+}
+
+// UpdateDevice saves the editable fields of device, returning false if it doesn't exist
+func (r *Repo) UpdateDevice(ctx context.Context, device *Device) (bool, error) {
+	res, err := r.db.
+		ModelContext(ctx, device).
+		Column("name", "uniqueid", "phone", "model", "contact", "category", "disabled", "groupid", "attributes").
+		WherePK().
+		Returning("*").
+		Update()
+	if err != nil {
+		return false, deviceError(err)
+	}
+
+	return res.RowsAffected() > 0, nil
+}
+
+// DeleteDevice removes the device with the ID along with its positions and events,
+// returning false if there's none
+func (r *Repo) DeleteDevice(ctx context.Context, id uint) (bool, error) {
+	res, err := r.db.ModelContext(ctx, &Device{ID: id}).WherePK().Delete()
+	if err != nil {
+		return false, err
+	}
+
+	return res.RowsAffected() > 0, nil
+}
+
+// deviceError converts constraint violations on tc_devices to errors callers can check
+func deviceError(err error) error {
+	pgErr, ok := err.(pg.Error)
+	if !ok {
+		return err
+	}
+
+	switch pgErr.Field('C') {
+	case "23505": // unique_violation
+		return ErrDuplicateDevice
+	case "23503": // foreign_key_violation
+		return ErrUnknownGroup
+	default:
+		return err
+	}
+}
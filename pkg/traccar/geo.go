@@ -0,0 +1,114 @@
+package traccar
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/go-pg/pg/v9/orm"
+	"tsaron.com/traccar-proxy/pkg/model"
+)
+
+const (
+	// mean radius of the earth in metres
+	earthRadius = 6371008.8
+	// length in metres of a degree of latitude, roughly
+	metresPerDegree = 111320.0
+
+	// haversineSQL is the distance in metres from a position's coordinates to the
+	// latitude and longitude passed as its parameters
+	haversineSQL = `2 * 6371008.8 * asin(sqrt(
+		power(sin(radians(latitude - ?0) / 2), 2) +
+		cos(radians(?0)) * cos(radians(latitude)) * power(sin(radians(longitude - ?1) / 2), 2)
+	))`
+)
+
+// BBox is an area bounded by lines of latitude and longitude
+type BBox struct {
+	MinLatitude  float64
+	MinLongitude float64
+	MaxLatitude  float64
+	MaxLongitude float64
+}
+
+// CircleBBox returns the smallest box that fits the circle of radius metres around center
+func CircleBBox(center model.Point, radius float64) BBox {
+	dLat := radius / metresPerDegree
+	// longitude lines get closer as we move to the poles
+	dLon := radius / (metresPerDegree * math.Max(math.Cos(radians(center.Latitude)), 0.01))
+
+	return BBox{
+		MinLatitude:  center.Latitude - dLat,
+		MinLongitude: center.Longitude - dLon,
+		MaxLatitude:  center.Latitude + dLat,
+		MaxLongitude: center.Longitude + dLon,
+	}
+}
+
+// PolygonBBox returns the smallest box that fits the polygon
+func PolygonBBox(polygon []model.Point) BBox {
+	box := BBox{
+		MinLatitude:  math.Inf(1),
+		MinLongitude: math.Inf(1),
+		MaxLatitude:  math.Inf(-1),
+		MaxLongitude: math.Inf(-1),
+	}
+
+	for _, p := range polygon {
+		box.MinLatitude = math.Min(box.MinLatitude, p.Latitude)
+		box.MinLongitude = math.Min(box.MinLongitude, p.Longitude)
+		box.MaxLatitude = math.Max(box.MaxLatitude, p.Latitude)
+		box.MaxLongitude = math.Max(box.MaxLongitude, p.Longitude)
+	}
+
+	return box
+}
+
+func withinBBox(query *orm.Query, box BBox) *orm.Query {
+	return query.
+		Where("latitude BETWEEN ? AND ?", box.MinLatitude, box.MaxLatitude).
+		Where("longitude BETWEEN ? AND ?", box.MinLongitude, box.MaxLongitude)
+}
+
+// polygonLiteral formats a polygon for postgres' geometric types, with x as the longitude
+func polygonLiteral(polygon []model.Point) string {
+	points := make([]string, len(polygon))
+	for i, p := range polygon {
+		points[i] = fmt.Sprintf("(%f,%f)", p.Longitude, p.Latitude)
+	}
+
+	return "(" + strings.Join(points, ",") + ")"
+}
+
+// Haversine returns the great-circle distance in metres between two coordinates
+func Haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	rLat1 := radians(lat1)
+	rLat2 := radians(lat2)
+	dLat := radians(lat2 - lat1)
+	dLon := radians(lon2 - lon1)
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(rLat1)*math.Cos(rLat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadius * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// InPolygon checks whether a coordinate falls inside a polygon using ray casting. The
+// polygon is closed implicitly, so the last point doesn't need to repeat the first.
+func InPolygon(lat, lon float64, polygon []model.Point) bool {
+	inside := false
+
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+
+		if (a.Latitude > lat) != (b.Latitude > lat) &&
+			lon < (b.Longitude-a.Longitude)*(lat-a.Latitude)/(b.Latitude-a.Latitude)+a.Longitude {
+			inside = !inside
+		}
+	}
+
+	return inside
+}
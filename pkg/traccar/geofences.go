@@ -0,0 +1,86 @@
+package traccar
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"tsaron.com/traccar-proxy/pkg/model"
+)
+
+// Geofence is an area managed by the proxy rather than traccar
+type Geofence struct {
+	tableName struct{} `pg:"proxy_geofences"`
+	ID        uint
+	Name      string
+	Type      string
+	Latitude  float64       `pg:",use_zero"`
+	Longitude float64       `pg:",use_zero"`
+	Radius    float64       `pg:",use_zero"`
+	Points    []model.Point `pg:",use_zero"`
+	CreatedAt time.Time     `pg:"default:now()"`
+	UpdatedAt time.Time     `pg:"default:now()"`
+}
+
+// Contains checks whether a coordinate is within the geofence
+func (g *Geofence) Contains(lat, lon float64) bool {
+	switch g.Type {
+	case model.GeofenceCircle:
+		return Haversine(g.Latitude, g.Longitude, lat, lon) <= g.Radius
+	case model.GeofencePolygon:
+		return InPolygon(lat, lon, g.Points)
+	default:
+		return false
+	}
+}
+
+func (r *Repo) Geofences(ctx context.Context) ([]Geofence, error) {
+	fences := []Geofence{}
+
+	err := r.db.ModelContext(ctx, &fences).Order("id ASC").Select()
+
+	return fences, err
+}
+
+func (r *Repo) FindGeofence(ctx context.Context, id uint) (*Geofence, error) {
+	fence := &Geofence{ID: id}
+
+	err := r.db.ModelContext(ctx, fence).WherePK().Select()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	}
+
+	return fence, err
+}
+
+func (r *Repo) CreateGeofence(ctx context.Context, fence *Geofence) error {
+	_, err := r.db.ModelContext(ctx, fence).Returning("*").Insert()
+	return err
+}
+
+// UpdateGeofence replaces the geofence with the same ID, returning false if there's none
+func (r *Repo) UpdateGeofence(ctx context.Context, fence *Geofence) (bool, error) {
+	fence.UpdatedAt = time.Now()
+
+	res, err := r.db.
+		ModelContext(ctx, fence).
+		Column("name", "type", "latitude", "longitude", "radius", "points", "updated_at").
+		WherePK().
+		Returning("*").
+		Update()
+	if err != nil {
+		return false, err
+	}
+
+	return res.RowsAffected() > 0, nil
+}
+
+// DeleteGeofence removes the geofence with the ID, returning false if there's none
+func (r *Repo) DeleteGeofence(ctx context.Context, id uint) (bool, error) {
+	res, err := r.db.ModelContext(ctx, &Geofence{ID: id}).WherePK().Delete()
+	if err != nil {
+		return false, err
+	}
+
+	return res.RowsAffected() > 0, nil
+}
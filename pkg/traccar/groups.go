@@ -0,0 +1,41 @@
+package traccar
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v9"
+)
+
+// groupTreeSQL selects the ID of a group along with those of all the groups below it
+const groupTreeSQL = `WITH RECURSIVE tree AS (
+	SELECT id FROM tc_groups WHERE id = ?
+	UNION
+	SELECT g.id FROM tc_groups AS g JOIN tree ON g.groupid = tree.id
+) SELECT id FROM tree`
+
+type Group struct {
+	tableName struct{} `pg:"tc_groups"`
+	ID        uint
+	Name      string
+	Parent    uint   `pg:"groupid"`
+	Payload   string `pg:"attributes"`
+}
+
+func (r *Repo) Groups(ctx context.Context) ([]Group, error) {
+	groups := []Group{}
+
+	err := r.db.ModelContext(ctx, &groups).Order("id ASC").Select()
+
+	return groups, err
+}
+
+func (r *Repo) FindGroup(ctx context.Context, id uint) (*Group, error) {
+	group := &Group{ID: id}
+
+	err := r.db.ModelContext(ctx, group).WherePK().Select()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	}
+
+	return group, err
+}
@@ -0,0 +1,180 @@
+package traccar
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"tsaron.com/traccar-proxy/pkg/model"
+)
+
+type SimplifyOpts struct {
+	// Drop points within this many metres of the simplified track (Douglas-Peucker)
+	Tolerance float64
+	// Keep only the first point in every period of this length
+	Interval time.Duration
+	// Keep at most this many points for each device, dropping the least significant first
+	MaxPoints int
+}
+
+// Enabled checks whether the options would simplify anything
+func (o SimplifyOpts) Enabled() bool {
+	return o.Tolerance > 0 || o.Interval > 0 || o.MaxPoints > 0
+}
+
+// Simplify reduces the positions of each device to fewer points that keep the shape of its track,
+// leaving them in the same order. The first and last positions of every device are always kept,
+// as are positions where the ignition turns on or off or the alarm changes.
+func Simplify(positions []model.Position, opts SimplifyOpts) []model.Position {
+	if !opts.Enabled() {
+		return positions
+	}
+
+	var devices []uint
+	tracks := make(map[uint][]int)
+	for i, p := range positions {
+		if _, ok := tracks[p.Device]; !ok {
+			devices = append(devices, p.Device)
+		}
+		tracks[p.Device] = append(tracks[p.Device], i)
+	}
+
+	keep := make([]bool, len(positions))
+	for _, d := range devices {
+		simplifyTrack(positions, tracks[d], opts, keep)
+	}
+
+	simplified := []model.Position{}
+	for i, p := range positions {
+		if keep[i] {
+			simplified = append(simplified, p)
+		}
+	}
+
+	return simplified
+}
+
+// simplifyTrack marks the positions at track (indexes into positions) that should be kept
+func simplifyTrack(positions []model.Position, track []int, opts SimplifyOpts, keep []bool) {
+	var candidates []int
+	var pinned []bool
+	buckets := make(map[int64]bool)
+
+	for j, i := range track {
+		p := positions[i]
+
+		pin := j == 0 || j == len(track)-1
+		if j > 0 {
+			prev := positions[track[j-1]]
+			pin = pin || p.Meta.Ignition != prev.Meta.Ignition || p.Meta.Alarm != prev.Meta.Alarm
+		}
+
+		if opts.Interval > 0 {
+			bucket := p.RecordedAt.UnixNano() / int64(opts.Interval)
+			if buckets[bucket] && !pin {
+				continue
+			}
+			buckets[bucket] = true
+		}
+
+		candidates = append(candidates, i)
+		pinned = append(pinned, pin)
+	}
+
+	points := make([]model.Point, len(candidates))
+	for j, i := range candidates {
+		points[j] = model.Point{Latitude: positions[i].Latitude, Longitude: positions[i].Longitude}
+	}
+	weights := dpWeights(points)
+
+	var loose []int
+	for j := range candidates {
+		switch {
+		case pinned[j]:
+			keep[candidates[j]] = true
+		case opts.Tolerance <= 0 || weights[j] > opts.Tolerance:
+			loose = append(loose, j)
+		}
+	}
+
+	if opts.MaxPoints > 0 {
+		room := opts.MaxPoints
+		for _, pin := range pinned {
+			if pin {
+				room--
+			}
+		}
+
+		if room < 0 {
+			room = 0
+		}
+
+		if len(loose) > room {
+			sort.SliceStable(loose, func(a, b int) bool { return weights[loose[a]] > weights[loose[b]] })
+			loose = loose[:room]
+		}
+	}
+
+	for _, j := range loose {
+		keep[candidates[j]] = true
+	}
+}
+
+// dpWeights ranks points by the Douglas-Peucker tolerance in metres above which they would be
+// dropped, so any tolerance or point budget can be applied with a single pass. The ends of
+// the track are never dropped.
+func dpWeights(points []model.Point) []float64 {
+	weights := make([]float64, len(points))
+	if len(points) == 0 {
+		return weights
+	}
+
+	weights[0], weights[len(points)-1] = math.Inf(1), math.Inf(1)
+
+	type span struct {
+		from, to int
+		max      float64
+	}
+
+	// a stack rather than recursion as days of positions can be very deep
+	stack := []span{{0, len(points) - 1, math.Inf(1)}}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if s.to-s.from < 2 {
+			continue
+		}
+
+		far, dist := s.from, -1.0
+		for i := s.from + 1; i < s.to; i++ {
+			if d := segmentDistance(points[i], points[s.from], points[s.to]); d > dist {
+				far, dist = i, d
+			}
+		}
+
+		// a point can't outlast the one that split the track above it
+		w := math.Min(dist, s.max)
+		weights[far] = w
+
+		stack = append(stack, span{s.from, far, w}, span{far, s.to, w})
+	}
+
+	return weights
+}
+
+// segmentDistance is the distance in metres from p to the segment between a and b,
+// treating the area around a as flat
+func segmentDistance(p, a, b model.Point) float64 {
+	scale := metresPerDegree * math.Cos(radians(a.Latitude))
+
+	px, py := (p.Longitude-a.Longitude)*scale, (p.Latitude-a.Latitude)*metresPerDegree
+	bx, by := (b.Longitude-a.Longitude)*scale, (b.Latitude-a.Latitude)*metresPerDegree
+
+	t := 0.0
+	if length := bx*bx + by*by; length > 0 {
+		t = math.Max(0, math.Min(1, (px*bx+py*by)/length))
+	}
+
+	return math.Hypot(px-t*bx, py-t*by)
+}
@@ -0,0 +1,130 @@
+package traccar
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"tsaron.com/traccar-proxy/pkg/model"
+)
+
+func TestDPWeights(t *testing.T) {
+	inf := math.Inf(1)
+
+	tests := []struct {
+		name   string
+		points []model.Point
+		want   []float64
+	}{
+		{"empty", nil, []float64{}},
+		{"single point", []model.Point{{}}, []float64{inf}},
+		{"two points", []model.Point{{}, {Longitude: 0.001}}, []float64{inf, inf}},
+		{
+			"straight line",
+			[]model.Point{{}, {Longitude: 0.001}, {Longitude: 0.002}},
+			[]float64{inf, 0, inf},
+		},
+		{
+			"bend",
+			[]model.Point{{}, {Latitude: 0.0001, Longitude: 0.001}, {Longitude: 0.002}},
+			[]float64{inf, 11.132, inf},
+		},
+		{
+			// the second point is far from the first split but can't outlast it
+			"capped by parent",
+			[]model.Point{{}, {Latitude: 0.00005, Longitude: 0.01}, {Latitude: 0.0001}, {Longitude: 0.02}},
+			[]float64{inf, 11.132, 11.132, inf},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := dpWeights(tt.points)
+			if len(got) != len(tt.want) {
+				t.Fatalf("dpWeights() = %v, want %v", got, tt.want)
+			}
+
+			for i := range got {
+				if math.IsInf(tt.want[i], 1) != math.IsInf(got[i], 1) || math.Abs(got[i]-tt.want[i]) > 0.01 {
+					t.Errorf("dpWeights()[%d] = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSimplify(t *testing.T) {
+	start := time.Unix(0, 0)
+	pos := func(id, device uint, latitude, longitude float64, seconds int) model.Position {
+		return model.Position{
+			ID:         id,
+			Device:     device,
+			Latitude:   latitude,
+			Longitude:  longitude,
+			RecordedAt: start.Add(time.Duration(seconds) * time.Second),
+		}
+	}
+
+	line := []model.Position{
+		pos(1, 1, 0, 0, 0),
+		pos(2, 1, 0, 0.001, 10),
+		pos(3, 1, 0, 0.002, 20),
+		pos(4, 1, 0, 0.003, 30),
+		pos(5, 1, 0, 0.004, 40),
+		pos(6, 1, 0, 0.005, 50),
+	}
+
+	zigzag := []model.Position{
+		pos(1, 1, 0, 0, 0),
+		pos(2, 1, 0.0001, 0.001, 10),
+		pos(3, 1, 0, 0.002, 20),
+		pos(4, 1, 0.001, 0.003, 30),
+		pos(5, 1, 0, 0.004, 40),
+	}
+
+	ignition := append([]model.Position{}, line...)
+	ignition[2].Meta.Ignition = true
+	ignition[3].Meta.Ignition = true
+
+	devices := []model.Position{
+		pos(1, 1, 0, 0, 0),
+		pos(2, 2, 1, 0, 0),
+		pos(3, 1, 0, 0.001, 10),
+		pos(4, 2, 1, 0.001, 10),
+		pos(5, 1, 0, 0.002, 20),
+		pos(6, 2, 1, 0.002, 20),
+	}
+
+	tests := []struct {
+		name      string
+		positions []model.Position
+		opts      SimplifyOpts
+		want      []uint
+	}{
+		{"disabled", line, SimplifyOpts{}, []uint{1, 2, 3, 4, 5, 6}},
+		{"no positions", nil, SimplifyOpts{Tolerance: 1}, []uint{}},
+		{"straight line", line, SimplifyOpts{Tolerance: 1}, []uint{1, 6}},
+		{"keeps bends over tolerance", zigzag, SimplifyOpts{Tolerance: 20}, []uint{1, 3, 4, 5}},
+		{"keeps every bend under tolerance", zigzag, SimplifyOpts{Tolerance: 5}, []uint{1, 2, 3, 4, 5}},
+		{"keeps ignition changes", ignition, SimplifyOpts{Tolerance: 1}, []uint{1, 3, 5, 6}},
+		{"interval", line, SimplifyOpts{Interval: 30 * time.Second}, []uint{1, 4, 6}},
+		{"interval keeps collinear points", line, SimplifyOpts{Interval: 20 * time.Second}, []uint{1, 3, 5, 6}},
+		{"max points", zigzag, SimplifyOpts{MaxPoints: 3}, []uint{1, 4, 5}},
+		{"max points below pinned", zigzag, SimplifyOpts{MaxPoints: 1}, []uint{1, 5}},
+		{"each device", devices, SimplifyOpts{Tolerance: 1}, []uint{1, 2, 5, 6}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []uint{}
+			for _, p := range Simplify(tt.positions, tt.opts) {
+				got = append(got, p.ID)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Simplify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
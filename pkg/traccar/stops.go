@@ -0,0 +1,66 @@
+package traccar
+
+import (
+	"time"
+
+	"tsaron.com/traccar-proxy/pkg/model"
+)
+
+type StopOpts struct {
+	// How long a device has to stay around the same place for it to count as a stop
+	MinDuration time.Duration
+	// How far in metres a device can drift from where it stopped and still be stopped
+	Radius float64
+}
+
+// DetectStops finds the periods positions, sorted oldest first, stay within Radius of
+// the first position of the period for at least MinDuration.
+func DetectStops(positions []model.Position, opts StopOpts) []model.Stop {
+	var stops []model.Stop
+
+	for i := 0; i < len(positions); {
+		anchor := positions[i]
+
+		end := i
+		for end+1 < len(positions) {
+			next := positions[end+1]
+			if Haversine(anchor.Latitude, anchor.Longitude, next.Latitude, next.Longitude) > opts.Radius {
+				break
+			}
+			end++
+		}
+
+		if positions[end].RecordedAt.Sub(anchor.RecordedAt) < opts.MinDuration {
+			i++
+			continue
+		}
+
+		stops = append(stops, summariseStop(positions[i:end+1]))
+		i = end + 1
+	}
+
+	return stops
+}
+
+func summariseStop(ps []model.Position) model.Stop {
+	first, last := ps[0], ps[len(ps)-1]
+
+	stop := model.Stop{
+		Device:     first.Device,
+		ArrivedAt:  first.RecordedAt,
+		DepartedAt: last.RecordedAt,
+		Duration:   int64(last.RecordedAt.Sub(first.RecordedAt).Seconds()),
+		Idling:     true,
+	}
+
+	// report the middle of everywhere the device drifted to
+	for _, p := range ps {
+		stop.Latitude += p.Latitude
+		stop.Longitude += p.Longitude
+		stop.Idling = stop.Idling && p.Meta.Ignition
+	}
+	stop.Latitude /= float64(len(ps))
+	stop.Longitude /= float64(len(ps))
+
+	return stop
+}
@@ -0,0 +1,88 @@
+package traccar
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"tsaron.com/traccar-proxy/pkg/model"
+)
+
+func TestDetectStops(t *testing.T) {
+	start := time.Unix(0, 0)
+	// a latitude of 0.00044 is about 48.9m from the equator and 0.00046 about 51.1m
+	pos := func(latitude float64, seconds int) model.Position {
+		return model.Position{Latitude: latitude, RecordedAt: start.Add(time.Duration(seconds) * time.Second)}
+	}
+
+	opts := StopOpts{MinDuration: time.Minute, Radius: 50}
+
+	tests := []struct {
+		name      string
+		positions []model.Position
+		want      [][2]int
+	}{
+		{"no positions", nil, nil},
+		{"single position", []model.Position{pos(0, 0)}, nil},
+		{"just under the minimum", []model.Position{pos(0, 0), pos(0, 59), pos(1, 70)}, nil},
+		{"just over the minimum", []model.Position{pos(0, 0), pos(0, 61), pos(1, 70)}, [][2]int{{0, 61}}},
+		{"drift inside the radius", []model.Position{pos(0, 0), pos(0.00044, 30), pos(0, 60)}, [][2]int{{0, 60}}},
+		{"drift outside the radius", []model.Position{pos(0, 0), pos(0.00046, 30), pos(0, 60)}, nil},
+		{
+			// the radius is from where the stop started, so slow drift ends it
+			"drift from the anchor",
+			[]model.Position{pos(0, 0), pos(0.0003, 30), pos(0.00046, 60), pos(0.00046, 120)},
+			[][2]int{{30, 120}},
+		},
+		{
+			"stopped at the end",
+			[]model.Position{pos(1, 0), pos(0, 10), pos(0, 100)},
+			[][2]int{{10, 100}},
+		},
+		{
+			"back to back",
+			[]model.Position{pos(0, 0), pos(0, 60), pos(1, 70), pos(1, 130)},
+			[][2]int{{0, 60}, {70, 130}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][2]int
+			for _, stop := range DetectStops(tt.positions, opts) {
+				got = append(got, [2]int{int(stop.ArrivedAt.Sub(start).Seconds()), int(stop.DepartedAt.Sub(start).Seconds())})
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DetectStops() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummariseStop(t *testing.T) {
+	start := time.Unix(0, 0)
+	ps := []model.Position{
+		{Device: 3, Latitude: 1, Longitude: 2, RecordedAt: start},
+		{Device: 3, Latitude: 3, Longitude: 4, RecordedAt: start.Add(90 * time.Second)},
+	}
+	ps[0].Meta.Ignition = true
+
+	want := model.Stop{
+		Device:     3,
+		Latitude:   2,
+		Longitude:  3,
+		ArrivedAt:  start,
+		DepartedAt: start.Add(90 * time.Second),
+		Duration:   90,
+	}
+
+	if got := summariseStop(ps); !reflect.DeepEqual(got, want) {
+		t.Errorf("summariseStop() = %+v, want %+v", got, want)
+	}
+
+	ps[1].Meta.Ignition = true
+	if got := summariseStop(ps); !got.Idling {
+		t.Errorf("summariseStop() Idling = false, want true")
+	}
+}
@@ -0,0 +1,99 @@
+package traccar
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"tsaron.com/traccar-proxy/pkg/model"
+)
+
+// summaryQuery rolls up positions per device and period. Counters reported by the device
+// (total distance, odometer, fuel) are summed as deltas between consecutive positions so
+// resets and period boundaries don't skew them. Distance falls back to the haversine
+// distance between positions when the device reports neither counter.
+const summaryQuery = `
+WITH attrs AS (
+	SELECT
+		p.deviceid,
+		p.devicetime,
+		p.speed,
+		p.latitude,
+		p.longitude,
+		CASE WHEN json_typeof(a.j->'totalDistance') = 'number' THEN (a.j->>'totalDistance')::float8 END AS total_distance,
+		CASE WHEN json_typeof(a.j->'odometer') = 'number' THEN (a.j->>'odometer')::float8 END AS odometer,
+		CASE WHEN json_typeof(a.j->'fuelConsumption') = 'number' THEN (a.j->>'fuelConsumption')::float8 END AS fuel,
+		CASE WHEN json_typeof(a.j->'tripFuelConsumption') = 'number' THEN (a.j->>'tripFuelConsumption')::float8 END AS trip_fuel,
+		CASE WHEN json_typeof(a.j->'ignition') = 'boolean' THEN (a.j->>'ignition')::boolean END AS ignition
+	FROM tc_positions p, LATERAL (SELECT p.attributes::json AS j) a
+	WHERE p.deviceid IN (?devices) AND p.devicetime BETWEEN ?from::timestamp AND ?to::timestamp
+), steps AS (
+	SELECT
+		*,
+		date_trunc(?interval, devicetime) AS period,
+		total_distance - lag(total_distance) OVER w AS total_distance_delta,
+		odometer - lag(odometer) OVER w AS odometer_delta,
+		fuel - lag(fuel) OVER w AS fuel_delta,
+		trip_fuel - lag(trip_fuel) OVER w AS trip_fuel_delta,
+		CASE WHEN lag(ignition) OVER w THEN extract(epoch FROM devicetime - lag(devicetime) OVER w) END AS ignition_seconds,
+		2 * 6371008.8 * asin(sqrt(
+			power(sin(radians(latitude - lag(latitude) OVER w) / 2), 2) +
+			cos(radians(lag(latitude) OVER w)) * cos(radians(latitude)) *
+			power(sin(radians(longitude - lag(longitude) OVER w) / 2), 2)
+		)) AS haversine
+	FROM attrs
+	WINDOW w AS (PARTITION BY deviceid ORDER BY devicetime)
+)
+SELECT
+	deviceid AS device,
+	period,
+	count(*) AS positions,
+	coalesce(
+		sum(total_distance_delta) FILTER (WHERE total_distance_delta >= 0),
+		sum(odometer_delta) FILTER (WHERE odometer_delta >= 0),
+		sum(haversine),
+		0
+	) AS distance,
+	coalesce(sum(ignition_seconds), 0) / 3600 AS engine_hours,
+	max(speed) AS max_speed,
+	avg(speed) AS average_speed,
+	coalesce(
+		sum(fuel_delta) FILTER (WHERE fuel_delta >= 0),
+		sum(trip_fuel_delta) FILTER (WHERE trip_fuel_delta >= 0),
+		0
+	) AS fuel_used
+FROM steps
+GROUP BY deviceid, period
+ORDER BY deviceid, period
+`
+
+// SummaryIntervals are the periods positions can be rolled up by
+var SummaryIntervals = map[string]bool{"day": true, "week": true, "month": true}
+
+// Summarise rolls up the positions of devices between from and to by the given interval,
+// which must be one of SummaryIntervals.
+func (r *Repo) Summarise(ctx context.Context, devices []uint, from, to time.Time, interval string) ([]model.Summary, error) {
+	if !SummaryIntervals[interval] || len(devices) == 0 {
+		return nil, ErrInvalidQuery
+	}
+
+	summaries := []model.Summary{}
+
+	_, err := r.db.QueryContext(ctx, &summaries, summaryQuery, struct {
+		Devices  pg.Ints
+		From     string
+		To       string
+		Interval string
+	}{toInts(devices), from.Format(pgTimef), to.Format(pgTimef), interval})
+
+	return summaries, err
+}
+
+func toInts(ids []uint) pg.Ints {
+	ints := make(pg.Ints, len(ids))
+	for i, id := range ids {
+		ints[i] = int64(id)
+	}
+
+	return ints
+}
@@ -16,7 +16,7 @@ type Device struct {
 	Model    string
 	Contact  string
 	Category string
-	Disabled bool
+	Disabled bool `pg:",use_zero"`
 	Group    uint `pg:"groupid"`
 }
 
@@ -39,3 +39,15 @@ type Position struct {
 	Network    string
 	FixedAt    time.Time `pg:"fixtime"`
 }
+
+type Event struct {
+	tableName   struct{} `pg:"tc_events"`
+	ID          uint
+	Type        string
+	CreatedAt   time.Time `pg:"servertime"`
+	Device      uint      `pg:"deviceid"`
+	Position    uint      `pg:"positionid"`
+	Geofence    uint      `pg:"geofenceid"`
+	Maintenance uint      `pg:"maintenanceid"`
+	Payload     string    `pg:"attributes"`
+}
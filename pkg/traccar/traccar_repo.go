@@ -5,16 +5,33 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"time"
 
 	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
 	"github.com/rs/zerolog"
 	"tsaron.com/traccar-proxy/pkg/model"
 )
 
-const pgTimef = "2006-01-02 15:04"
+const (
+	pgTimef = "2006-01-02 15:04"
 
-var ErrInvalidQuery = errors.New("your query is invalid")
+	// channel used to check the health of listener connections
+	pingChannel = "traccar-proxy.ping"
+	// how long to wait for a notification before pinging the connection
+	listenTimeout = 30 * time.Second
+	// how long to wait before reconnecting a failed listener
+	listenBackoff = 5 * time.Second
+	// how many rows to fetch from a cursor at a time
+	cursorBatch = 1000
+)
+
+var (
+	ErrInvalidQuery    = errors.New("your query is invalid")
+	ErrDuplicateDevice = errors.New("a device with the external ID already exists")
+	ErrUnknownGroup    = errors.New("the group does not exist")
+)
 
 type Repo struct {
 	log     zerolog.Logger
@@ -33,35 +50,100 @@ type tableEvent struct {
 	Data   interface{} `json:"data"`
 }
 
-func (r *Repo) Listen(ctx context.Context, table string, out chan<- []byte) {
-	l := r.db.Listen(r.channel)
-	defer l.Close()
-
-	ch := l.Channel()
+// Listen forwards notifications for table to out until ctx is done. The listener reconnects
+// when the connection to postgres fails, sending on connected (if not nil) every time it starts
+// listening so callers can catch up on whatever they missed.
+func (r *Repo) Listen(ctx context.Context, table string, out chan<- []byte, connected chan<- struct{}) {
 	for {
+		r.listen(ctx, table, out, connected)
+
 		select {
 		case <-ctx.Done():
 			return
-		case n := <-ch:
-			e := new(tableEvent)
+		case <-time.After(listenBackoff):
+			r.log.Info().Str("table", table).Msg("reconnecting listener")
+		}
+	}
+}
 
-			if err := json.Unmarshal([]byte(n.Payload), e); err != nil {
-				r.log.Err(err).Msg("failed to decode event payload")
-				continue
-			}
+// listen runs a single listener connection till it breaks or ctx is done
+func (r *Repo) listen(ctx context.Context, table string, out chan<- []byte, connected chan<- struct{}) {
+	l := r.db.Listen()
+	if err := l.Listen(r.channel, pingChannel); err != nil {
+		r.log.Err(err).Msg("failed to start listener")
+		_ = l.Close()
+		return
+	}
+
+	// closing the listener is the only way to interrupt a blocked receive
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stop:
+		}
+		_ = l.Close()
+	}()
 
-			if e.Table != table {
+	if connected != nil {
+		select {
+		case connected <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	pinged := false
+	for {
+		channel, payload, err := l.ReceiveTimeout(listenTimeout)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			// make sure the connection is still alive before waiting again
+			if nErr, ok := err.(net.Error); ok && nErr.Timeout() && !pinged {
+				if _, err := r.db.ExecContext(ctx, "SELECT pg_notify(?, '')", pingChannel); err != nil {
+					r.log.Err(err).Msg("failed to ping listener")
+					return
+				}
+				pinged = true
 				continue
 			}
 
-			r.log.
-				Info().
-				Str("table", e.Table).
-				Str("action", e.Action).
-				Interface("data", e.Data).
-				Msg("received event")
+			r.log.Err(err).Msg("listener connection failed")
+			return
+		}
+
+		// any notification is as good as a ping
+		pinged = false
+		if channel == pingChannel {
+			continue
+		}
+
+		e := new(tableEvent)
+
+		if err := json.Unmarshal([]byte(payload), e); err != nil {
+			r.log.Err(err).Msg("failed to decode event payload")
+			continue
+		}
+
+		if e.Table != table {
+			continue
+		}
+
+		r.log.
+			Info().
+			Str("table", e.Table).
+			Str("action", e.Action).
+			Interface("data", e.Data).
+			Msg("received event")
 
-			out <- []byte(n.Payload)
+		select {
+		case out <- []byte(payload):
+		case <-ctx.Done():
+			return
 		}
 	}
 }
@@ -81,6 +163,21 @@ func (r *Repo) FindDevice(ctx context.Context, externalID string) (*Device, erro
 	return device, err
 }
 
+func (r *Repo) FindDeviceByID(ctx context.Context, id uint) (*Device, error) {
+	device := &Device{ID: id}
+
+	err := r.db.
+		ModelContext(ctx, device).
+		WherePK().
+		Select()
+
+	if err == pg.ErrNoRows {
+		return nil, nil
+	}
+
+	return device, err
+}
+
 func (r *Repo) LatestPosition(ctx context.Context, device uint) (*Position, error) {
 	position := &Position{}
 	err := r.db.
@@ -97,7 +194,69 @@ func (r *Repo) LatestPosition(ctx context.Context, device uint) (*Position, erro
 	return position, err
 }
 
+// DeviceFilter picks devices by their IDs, external IDs or group (including its subgroups).
+// Devices matching any of the criteria are picked, and an empty filter picks every device.
+type DeviceFilter struct {
+	Devices     []uint
+	ExternalIDs []string
+	Group       uint
+}
+
+// LatestPositions returns the position traccar considers the latest for every device matching
+// filter, in a single query.
+func (r *Repo) LatestPositions(ctx context.Context, filter DeviceFilter) ([]Position, error) {
+	positions := []Position{}
+
+	err := r.db.
+		ModelContext(ctx, &positions).
+		Join("JOIN tc_devices AS d ON d.positionid = position.id").
+		Apply(filter.apply).
+		Order("position.deviceid ASC").
+		Select()
+
+	return positions, err
+}
+
+// DeviceIDs returns the IDs of the devices matching filter
+func (r *Repo) DeviceIDs(ctx context.Context, filter DeviceFilter) ([]uint, error) {
+	var ids []uint
+
+	err := r.db.
+		ModelContext(ctx).
+		TableExpr("tc_devices AS d").
+		ColumnExpr("array_agg(d.id)").
+		Apply(filter.apply).
+		Select(pg.Array(&ids))
+
+	return ids, err
+}
+
+// apply adds the filter to a query that has tc_devices aliased as d
+func (f DeviceFilter) apply(q *orm.Query) (*orm.Query, error) {
+	if len(f.Devices) == 0 && len(f.ExternalIDs) == 0 && f.Group == 0 {
+		return q, nil
+	}
+
+	return q.WhereGroup(func(q *orm.Query) (*orm.Query, error) {
+		if len(f.Devices) > 0 {
+			q = q.WhereOr("d.id IN (?)", pg.In(f.Devices))
+		}
+
+		if len(f.ExternalIDs) > 0 {
+			q = q.WhereOr("d.uniqueid IN (?)", pg.In(f.ExternalIDs))
+		}
+
+		if f.Group != 0 {
+			q = q.WhereOr("d.groupid IN ("+groupTreeSQL+")", f.Group)
+		}
+
+		return q, nil
+	}), nil
+}
+
 type QueryOpts struct {
+	// Devices whose positions should be returned. Positions of all devices are returned if it's empty.
+	Devices []uint
 	// The oldest position by devicetime. If this is not set, time based query is entirely ignroed
 	From time.Time
 	// The latest position by devicetime.
@@ -109,26 +268,145 @@ type QueryOpts struct {
 	// Order of the results. Could be "oldest" meaning oldest first or "latest" meaning latest first
 	// arranged by the devicetime of the positions.
 	Order string
+	// Only return positions within this box
+	BBox *BBox
+	// Only return positions within Radius metres of Center
+	Center *model.Point
+	Radius float64
+	// Only return positions inside this polygon
+	Polygon []model.Point
+	// Keep the positions of each device together, ordered by device ID
+	ByDevice bool
+	// Only return positions after this one in the order of the results. It can't be
+	// used with Offset or ByDevice.
+	After *PositionCursor
+}
+
+// PositionCursor marks the last position of a page by its devicetime and ID
+type PositionCursor struct {
+	RecordedAt time.Time `json:"t"`
+	ID         uint      `json:"id"`
 }
 
-func (r *Repo) FindPositions(ctx context.Context, device uint, opts QueryOpts) ([]Position, error) {
+// CursorOf returns the cursor that picks up after p
+func CursorOf(p *Position) *PositionCursor {
+	return &PositionCursor{RecordedAt: p.RecordedAt, ID: p.ID}
+}
+
+func (r *Repo) FindPositions(ctx context.Context, opts QueryOpts) ([]Position, error) {
 	positions := []Position{}
 
-	order := "devicetime"
+	query, err := positionsQuery(r.db.ModelContext(ctx, &positions), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	err = query.Select()
+
+	return positions, err
+}
+
+// EachPosition calls fn with every position matching opts, reading them through a cursor
+// so large results don't have to be held in memory.
+func (r *Repo) EachPosition(ctx context.Context, opts QueryOpts, fn func(*Position) error) error {
+	query, err := positionsQuery(r.db.ModelContext(ctx, (*Position)(nil)), opts)
+	if err != nil {
+		return err
+	}
+
+	sql, err := query.AppendQuery(r.db.Formatter(), nil)
+	if err != nil {
+		return err
+	}
+
+	// cursors only live as long as the transaction that declares them
+	return r.db.WithContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DECLARE positions_cursor NO SCROLL CURSOR FOR ?", pg.Safe(sql)); err != nil {
+			return err
+		}
+
+		for {
+			// stop reading once the client has gone
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			var batch []Position
+			if _, err := tx.QueryContext(ctx, &batch, "FETCH ? FROM positions_cursor", cursorBatch); err != nil {
+				return err
+			}
+
+			for i := range batch {
+				if err := fn(&batch[i]); err != nil {
+					return err
+				}
+			}
+
+			if len(batch) < cursorBatch {
+				return nil
+			}
+		}
+	})
+}
+
+// positionsQuery adds the filters and ordering in opts to a query on positions
+func positionsQuery(query *orm.Query, opts QueryOpts) (*orm.Query, error) {
+	var dir, cmp string
 	switch opts.Order {
 	case "oldest":
-		order += " ASC"
+		dir, cmp = "ASC", ">"
 	case "latest":
-		order += " DESC"
+		dir, cmp = "DESC", "<"
 	default:
 		return nil, ErrInvalidQuery
 	}
 
-	query := r.db.
-		ModelContext(ctx, &positions).
-		Where("deviceid = ?", device).
+	if opts.ByDevice {
+		query = query.Order("deviceid ASC")
+	}
+
+	// the ID breaks ties between positions recorded at the same time so pages are stable
+	query = query.
 		Offset(opts.Offset).
-		Order(order)
+		Order("devicetime "+dir, "id "+dir)
+
+	if opts.After != nil {
+		if opts.Offset != 0 || opts.ByDevice {
+			return nil, ErrInvalidQuery
+		}
+
+		query = query.Where(
+			"(devicetime, id) "+cmp+" (?::timestamp, ?)",
+			opts.After.RecordedAt.Format(pgTimestampf), opts.After.ID,
+		)
+	}
+
+	if len(opts.Devices) > 0 {
+		query = query.Where("deviceid IN (?)", pg.In(opts.Devices))
+	}
+
+	if (opts.Center == nil) != (opts.Radius == 0) {
+		return nil, ErrInvalidQuery
+	}
+
+	if opts.BBox != nil {
+		query = withinBBox(query, *opts.BBox)
+	}
+
+	if opts.Center != nil {
+		// the box around the circle lets postgres skip most rows before the exact check
+		query = withinBBox(query, CircleBBox(*opts.Center, opts.Radius)).
+			Where(haversineSQL+" <= ?2", opts.Center.Latitude, opts.Center.Longitude, opts.Radius)
+	}
+
+	if len(opts.Polygon) > 0 {
+		if len(opts.Polygon) < 3 {
+			return nil, ErrInvalidQuery
+		}
+
+		query = withinBBox(query, PolygonBBox(opts.Polygon)).
+			Where("?::polygon @> point(longitude, latitude)", polygonLiteral(opts.Polygon))
+	}
 
 	switch {
 	case !opts.From.IsZero() && !opts.To.IsZero():
@@ -140,14 +418,11 @@ func (r *Repo) FindPositions(ctx context.Context, device uint, opts QueryOpts) (
 		return nil, ErrInvalidQuery
 	}
 
-	var err error
-	if opts.Limit == 0 {
-		err = query.Select()
-	} else {
-		err = query.Limit(opts.Limit).Select()
+	if opts.Limit != 0 {
+		query = query.Limit(opts.Limit)
 	}
 
-	return positions, err
+	return query, nil
 }
 
 func (r *Repo) RemoveTZ(p *Position) model.TraccarPosition {
@@ -0,0 +1,116 @@
+package traccar
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"tsaron.com/traccar-proxy/pkg/model"
+)
+
+func TestPositionsQuery(t *testing.T) {
+	// queries are only built, never sent
+	db := pg.Connect(&pg.Options{})
+	defer db.Close()
+
+	from := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+	after := &PositionCursor{RecordedAt: from.Add(time.Minute + 1500*time.Microsecond), ID: 9}
+
+	tests := []struct {
+		name    string
+		opts    QueryOpts
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "devices",
+			opts: QueryOpts{Devices: []uint{1, 2}, Order: "latest", Limit: 10},
+			want: `WHERE (deviceid IN (1,2)) ORDER BY "devicetime" DESC, "id" DESC LIMIT 10`,
+		},
+		{
+			name: "time range",
+			opts: QueryOpts{Devices: []uint{1}, Order: "oldest", From: from, To: to},
+			want: `WHERE (deviceid IN (1)) AND ('[2021-03-01 00:00, 2021-03-01 01:00]'::tsrange @> devicetime) ` +
+				`ORDER BY "devicetime" ASC, "id" ASC`,
+		},
+		{
+			name: "by device",
+			opts: QueryOpts{Devices: []uint{1}, Order: "oldest", ByDevice: true, Offset: 5},
+			want: `WHERE (deviceid IN (1)) ORDER BY "deviceid" ASC, "devicetime" ASC, "id" ASC OFFSET 5`,
+		},
+		{
+			name: "after latest",
+			opts: QueryOpts{Devices: []uint{1}, Order: "latest", Limit: 10, After: after},
+			want: `WHERE ((devicetime, id) < ('2021-03-01 00:01:00.0015'::timestamp, 9)) AND (deviceid IN (1)) ` +
+				`ORDER BY "devicetime" DESC, "id" DESC LIMIT 10`,
+		},
+		{
+			name: "after oldest",
+			opts: QueryOpts{Devices: []uint{1}, Order: "oldest", After: after},
+			want: `WHERE ((devicetime, id) > ('2021-03-01 00:01:00.0015'::timestamp, 9)) AND (deviceid IN (1)) ` +
+				`ORDER BY "devicetime" ASC, "id" ASC`,
+		},
+		{name: "unknown order", opts: QueryOpts{Order: "newest"}, wantErr: true},
+		{name: "after with offset", opts: QueryOpts{Order: "latest", Offset: 10, After: after}, wantErr: true},
+		{name: "after by device", opts: QueryOpts{Order: "latest", ByDevice: true, After: after}, wantErr: true},
+		{name: "only from", opts: QueryOpts{Order: "latest", From: from}, wantErr: true},
+		{name: "center without radius", opts: QueryOpts{Order: "latest", Center: &model.Point{}}, wantErr: true},
+		{
+			name:    "polygon of two points",
+			opts:    QueryOpts{Order: "latest", Polygon: []model.Point{{}, {Latitude: 1}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := positionsQuery(db.Model((*Position)(nil)), tt.opts)
+			if tt.wantErr {
+				if err != ErrInvalidQuery {
+					t.Fatalf("positionsQuery() error = %v, want ErrInvalidQuery", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("positionsQuery() error = %v", err)
+			}
+
+			sql, err := query.AppendQuery(db.Formatter(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := string(sql)
+			if i := strings.Index(got, `AS "position" `); i >= 0 {
+				got = got[i+len(`AS "position" `):]
+			}
+
+			if got != tt.want {
+				t.Errorf("positionsQuery() = %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPositionCursorJSON(t *testing.T) {
+	p := &Position{ID: 42, RecordedAt: time.Date(2021, 3, 1, 10, 30, 15, 123456000, time.UTC)}
+
+	raw, err := json.Marshal(CursorOf(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cursor PositionCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		t.Fatal(err)
+	}
+
+	// a cursor that loses precision would skip or repeat positions
+	if cursor.ID != p.ID || !cursor.RecordedAt.Equal(p.RecordedAt) {
+		t.Errorf("cursor = %+v, want %+v", cursor, *CursorOf(p))
+	}
+}
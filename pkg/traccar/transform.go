@@ -2,13 +2,16 @@ package traccar
 
 import (
 	"encoding/json"
+	"reflect"
 	"time"
 
 	"github.com/pkg/errors"
 	"tsaron.com/traccar-proxy/pkg/model"
 )
 
-func TransformPosition(p model.TraccarPosition) (model.Position, error) {
+// TransformPosition converts a position from traccar's format. Attributes that can't be
+// read are reported in the position's warnings rather than failing the whole position.
+func TransformPosition(p model.TraccarPosition) model.Position {
 	pos := model.Position{
 		ID:         p.ID,
 		CreatedAt:  time.Time(p.CreatedAt),
@@ -23,9 +26,8 @@ func TransformPosition(p model.TraccarPosition) (model.Position, error) {
 	}
 
 	var attr model.TraccarAttributes
-	if err := json.Unmarshal([]byte(p.Payload), &attr); err != nil {
-		return pos, errors.Wrap(err, "could not decode attributes")
-	}
+	raw, warnings := decodeAttributes(p.Payload, &attr)
+	pos.Warnings = warnings
 
 	pos.Meta = model.Attributes{
 		FuelConsumption:     attr.FuelConsumption,
@@ -47,7 +49,139 @@ func TransformPosition(p model.TraccarPosition) (model.Position, error) {
 		MilDistance:         attr.MilDistance,
 		Satellites:          attr.Satellites,
 		TripFuelConsumption: attr.TripFuelConsumption,
+		// protocols report all sorts of attributes we don't have fields for
+		Extra: extraAttributes(raw),
+	}
+
+	return pos
+}
+
+func TransformEvent(e model.TraccarEvent) (model.Event, error) {
+	ev := model.Event{
+		ID:          e.ID,
+		Type:        e.Type,
+		CreatedAt:   time.Time(e.CreatedAt),
+		Device:      e.Device,
+		Position:    e.Position,
+		Geofence:    e.Geofence,
+		Maintenance: e.Maintenance,
+	}
+
+	// events like deviceOnline don't carry any attributes
+	if e.Payload == "" {
+		return ev, nil
+	}
+
+	var attr model.TraccarEventAttributes
+	if err := json.Unmarshal([]byte(e.Payload), &attr); err != nil {
+		return ev, errors.Wrap(err, "could not decode attributes")
+	}
+
+	ev.Meta = model.EventAttributes{
+		Alarm:      attr.Alarm,
+		Speed:      attr.Speed,
+		SpeedLimit: attr.SpeedLimit,
+		Message:    attr.Message,
+		Result:     attr.Result,
+		Driver:     attr.DriverUniqueID,
+	}
+
+	return ev, nil
+}
+
+func TransformDevice(d model.TraccarDevice) (model.Device, error) {
+	dev := model.Device{
+		ID:           d.ID,
+		Name:         d.Name,
+		ExternalID:   d.ExternalID,
+		Phone:        d.Phone,
+		Model:        d.Model,
+		Contact:      d.Contact,
+		Category:     d.Category,
+		Group:        d.Group,
+		Disabled:     d.Disabled,
+		LastPosition: d.Position,
+	}
+
+	// devices that never connected have no last update
+	if t := time.Time(d.UpdatedAt); !t.IsZero() {
+		dev.LastUpdate = &t
+	}
+
+	if d.Payload == "" {
+		return dev, nil
+	}
+
+	if err := json.Unmarshal([]byte(d.Payload), &dev.Attributes); err != nil {
+		return dev, errors.Wrap(err, "could not decode attributes")
+	}
+
+	return dev, nil
+}
+
+// DiffDevices returns the tracked fields that differ between old and new, keyed by
+// their JSON names. It returns an empty map when nothing we care about changed.
+func DiffDevices(old, new model.Device) map[string]model.FieldChange {
+	changes := make(map[string]model.FieldChange)
+
+	if old.Name != new.Name {
+		changes["name"] = model.FieldChange{From: old.Name, To: new.Name}
+	}
+
+	if old.ExternalID != new.ExternalID {
+		changes["external_id"] = model.FieldChange{From: old.ExternalID, To: new.ExternalID}
+	}
+
+	for name, values := range map[string][2]string{
+		"phone":    {old.Phone, new.Phone},
+		"model":    {old.Model, new.Model},
+		"contact":  {old.Contact, new.Contact},
+		"category": {old.Category, new.Category},
+	} {
+		if values[0] != values[1] {
+			changes[name] = model.FieldChange{From: values[0], To: values[1]}
+		}
+	}
+
+	if old.Group != new.Group {
+		changes["group_id"] = model.FieldChange{From: old.Group, To: new.Group}
+	}
+
+	if old.Disabled != new.Disabled {
+		changes["disabled"] = model.FieldChange{From: old.Disabled, To: new.Disabled}
+	}
+
+	if !reflect.DeepEqual(old.Attributes, new.Attributes) {
+		changes["attributes"] = model.FieldChange{From: old.Attributes, To: new.Attributes}
+	}
+
+	return changes
+}
+
+func TransformGeofence(g *Geofence) model.Geofence {
+	return model.Geofence{
+		ID:        g.ID,
+		Name:      g.Name,
+		Type:      g.Type,
+		Latitude:  g.Latitude,
+		Longitude: g.Longitude,
+		Radius:    g.Radius,
+		Points:    g.Points,
+		CreatedAt: g.CreatedAt,
+		UpdatedAt: g.UpdatedAt,
+	}
+}
+
+func TransformGroup(g *Group) (model.Group, error) {
+	group := model.Group{ID: g.ID, Name: g.Name, Parent: g.Parent}
+
+	if g.Payload == "" {
+		return group, nil
+	}
+
+	if err := json.Unmarshal([]byte(g.Payload), &group.Attributes); err != nil {
+		return group, errors.Wrap(err, "could not decode attributes")
 	}
 
-	return pos, nil
+	return group, nil
 }
@@ -0,0 +1,118 @@
+package traccar
+
+import (
+	"time"
+
+	"tsaron.com/traccar-proxy/pkg/model"
+)
+
+type TripOpts struct {
+	// How long a device has to stay still before the trip it's on ends
+	MinStopDuration time.Duration
+	// Speed in knots above which a device is considered moving even without the motion attribute
+	SpeedThreshold float64
+	// Use the ignition attribute rather than motion and speed to decide when a device is moving
+	UseIgnition bool
+}
+
+// DetectTrips splits positions, sorted oldest first, into trips. A trip starts at the first
+// moving position and ends at the first position of a stop that lasts at least MinStopDuration.
+// A trip still in progress ends at the last position.
+func DetectTrips(positions []model.Position, opts TripOpts) []model.Trip {
+	var trips []model.Trip
+
+	start, stop := -1, -1
+	for i, p := range positions {
+		moving := isMoving(p, opts)
+
+		switch {
+		case start == -1 && moving:
+			start = i
+		case start != -1 && moving:
+			stop = -1
+		case start != -1 && stop == -1:
+			stop = i
+		}
+
+		if stop != -1 && p.RecordedAt.Sub(positions[stop].RecordedAt) >= opts.MinStopDuration {
+			trips = append(trips, summariseTrip(positions[start:stop+1]))
+			start, stop = -1, -1
+		}
+	}
+
+	if start != -1 {
+		end := len(positions) - 1
+		if stop != -1 {
+			end = stop
+		}
+		trips = append(trips, summariseTrip(positions[start:end+1]))
+	}
+
+	return trips
+}
+
+func isMoving(p model.Position, opts TripOpts) bool {
+	if opts.UseIgnition {
+		return p.Meta.Ignition
+	}
+
+	return p.Meta.Motion || p.Speed > opts.SpeedThreshold
+}
+
+func summariseTrip(ps []model.Position) model.Trip {
+	first, last := ps[0], ps[len(ps)-1]
+
+	trip := model.Trip{
+		Device:         first.Device,
+		StartedAt:      first.RecordedAt,
+		EndedAt:        last.RecordedAt,
+		StartLatitude:  first.Latitude,
+		StartLongitude: first.Longitude,
+		EndLatitude:    last.Latitude,
+		EndLongitude:   last.Longitude,
+		Duration:       int64(last.RecordedAt.Sub(first.RecordedAt).Seconds()),
+		Distance:       PathDistance(ps),
+		FuelUsed:       fuelUsed(first, last),
+	}
+
+	var total float64
+	for _, p := range ps {
+		total += p.Speed
+		if p.Speed > trip.MaxSpeed {
+			trip.MaxSpeed = p.Speed
+		}
+	}
+	trip.AverageSpeed = total / float64(len(ps))
+
+	return trip
+}
+
+// PathDistance is the distance in metres covered going through positions in order.
+// It trusts the device's total distance when both ends report it.
+func PathDistance(ps []model.Position) float64 {
+	if len(ps) < 2 {
+		return 0
+	}
+
+	first, last := ps[0], ps[len(ps)-1]
+	if first.Meta.TotalDistance > 0 && last.Meta.TotalDistance >= first.Meta.TotalDistance {
+		return float64(last.Meta.TotalDistance - first.Meta.TotalDistance)
+	}
+
+	var d float64
+	for i := 1; i < len(ps); i++ {
+		d += Haversine(ps[i-1].Latitude, ps[i-1].Longitude, ps[i].Latitude, ps[i].Longitude)
+	}
+
+	return d
+}
+
+// fuelUsed is the difference in the fuel consumed counter between two positions, if the
+// device reports it.
+func fuelUsed(first, last model.Position) float32 {
+	if first.Meta.FuelConsumption > 0 && last.Meta.FuelConsumption >= first.Meta.FuelConsumption {
+		return last.Meta.FuelConsumption - first.Meta.FuelConsumption
+	}
+
+	return 0
+}
@@ -0,0 +1,89 @@
+package traccar
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"tsaron.com/traccar-proxy/pkg/model"
+)
+
+func TestDetectTrips(t *testing.T) {
+	start := time.Unix(0, 0)
+	moving := func(seconds int) model.Position {
+		return model.Position{Speed: 10, RecordedAt: start.Add(time.Duration(seconds) * time.Second)}
+	}
+	still := func(seconds int) model.Position {
+		return model.Position{RecordedAt: start.Add(time.Duration(seconds) * time.Second)}
+	}
+	ignition := func(p model.Position, on bool) model.Position {
+		p.Meta.Ignition = on
+		return p
+	}
+	motion := still(10)
+	motion.Meta.Motion = true
+
+	opts := TripOpts{MinStopDuration: time.Minute, SpeedThreshold: 1}
+	ignitionOpts := TripOpts{MinStopDuration: time.Minute, SpeedThreshold: 1, UseIgnition: true}
+
+	tests := []struct {
+		name      string
+		positions []model.Position
+		opts      TripOpts
+		want      [][2]int
+	}{
+		{"no positions", nil, opts, nil},
+		{"never moves", []model.Position{still(0), still(100)}, opts, nil},
+		{
+			"stop just under the minimum",
+			[]model.Position{moving(0), moving(10), still(20), still(79), moving(90), still(100), still(160)},
+			opts,
+			[][2]int{{0, 100}},
+		},
+		{
+			"stop just over the minimum",
+			[]model.Position{moving(0), moving(10), still(20), still(81), moving(90), still(100), still(160)},
+			opts,
+			[][2]int{{0, 20}, {90, 100}},
+		},
+		{"in progress", []model.Position{still(0), moving(10), moving(20)}, opts, [][2]int{{10, 20}}},
+		{
+			// the trip ends where the device stopped even if it hasn't stopped for long
+			"in progress after a short stop",
+			[]model.Position{moving(0), moving(10), still(20), still(30)},
+			opts,
+			[][2]int{{0, 20}},
+		},
+		{"motion without speed", []model.Position{still(0), motion, still(20), still(80)}, opts, [][2]int{{10, 20}}},
+		{
+			"ignition",
+			[]model.Position{
+				ignition(still(0), true),
+				ignition(still(10), true),
+				ignition(moving(20), false),
+				ignition(moving(80), false),
+			},
+			ignitionOpts,
+			[][2]int{{0, 20}},
+		},
+		{
+			"ignition ignores speed",
+			[]model.Position{moving(0), moving(10), moving(20)},
+			ignitionOpts,
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][2]int
+			for _, trip := range DetectTrips(tt.positions, tt.opts) {
+				got = append(got, [2]int{int(trip.StartedAt.Sub(start).Seconds()), int(trip.EndedAt.Sub(start).Seconds())})
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DetectTrips() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
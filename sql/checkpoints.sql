@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS proxy_checkpoints (
+    name        TEXT PRIMARY KEY,
+    position_id BIGINT NOT NULL DEFAULT 0,
+    updated_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
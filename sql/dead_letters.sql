@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS proxy_dead_letters (
+    id         BIGSERIAL PRIMARY KEY,
+    url        TEXT NOT NULL,
+    subject    TEXT NOT NULL,
+    message_id TEXT,
+    body       TEXT NOT NULL,
+    error      TEXT NOT NULL,
+    attempts   INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
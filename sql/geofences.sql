@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS proxy_geofences (
+    id         BIGSERIAL PRIMARY KEY,
+    name       TEXT NOT NULL,
+    type       TEXT NOT NULL CHECK (type IN ('circle', 'polygon')),
+    latitude   DOUBLE PRECISION NOT NULL DEFAULT 0,
+    longitude  DOUBLE PRECISION NOT NULL DEFAULT 0,
+    radius     DOUBLE PRECISION NOT NULL DEFAULT 0,
+    points     JSONB,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
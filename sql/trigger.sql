@@ -2,6 +2,7 @@ CREATE OR REPLACE FUNCTION notify_event() RETURNS TRIGGER AS $$
                                      
     DECLARE                         
         data json;
+        old_data json;
         notification json;
                                                     
     BEGIN                                        
@@ -14,12 +15,32 @@ CREATE OR REPLACE FUNCTION notify_event() RETURNS TRIGGER AS $$
         ELSE                           
             data = row_to_json(NEW);
         END IF;
+
+        -- Keep the previous values of the columns an update changed so consumers
+        -- can diff them. Only changed columns are sent to keep the payload small.
+        IF (TG_OP = 'UPDATE') THEN
+            SELECT coalesce(json_object_agg(o.key, o.value), '{}'::json) INTO old_data
+            FROM jsonb_each(to_jsonb(OLD)) o
+            JOIN jsonb_each(to_jsonb(NEW)) n ON n.key = o.key
+            WHERE o.value IS DISTINCT FROM n.value;
+        END IF;
         
         -- Contruct the notification as a JSON string.
         notification = json_build_object(
                           'table',TG_TABLE_NAME,
                           'action', TG_OP,
-                          'data', data);
+                          'data', data,
+                          'old', old_data);
+
+        -- pg_notify fails on payloads of 8000 bytes or more, which would abort
+        -- traccar's own write. Drop the previous values rather than let that happen,
+        -- in which case updates are published without their changes.
+        IF (octet_length(notification::text) >= 8000) THEN
+            notification = json_build_object(
+                              'table',TG_TABLE_NAME,
+                              'action', TG_OP,
+                              'data', data);
+        END IF;
         
                         
         -- Execute pg_notify(channel, notification)